@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v3"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errAuditFailed = errs.Class("audit failed")
+)
+
+func auditCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "audit",
+		Usage: "Report installed binaries that were revoked by their repository",
+		Action: func(_ context.Context, c *cli.Command) error {
+			config, err := loadConfig()
+			if err != nil {
+				return errAuditFailed.Wrap(err)
+			}
+			return audit(config, arrStringToArrBinaryEntry(c.Args().Slice()))
+		},
+	}
+}
+
+func audit(config *config, programsToAudit []binaryEntry) error {
+	revoked, err := fetchRevocationLists(config)
+	if err != nil {
+		return errAuditFailed.Wrap(err)
+	}
+
+	programs, err := validateProgramsFrom(config, programsToAudit, nil)
+	if err != nil {
+		return errAuditFailed.Wrap(err)
+	}
+
+	var found int
+	for _, program := range programs {
+		binaryPath := filepath.Join(config.InstallDir, filepath.Base(program.Name))
+		r, err := revocationOfInstalled(revoked, binaryPath)
+		if err != nil {
+			if verbosityLevel >= silentVerbosityWithErrors {
+				fmt.Fprintf(os.Stderr, "Warning: could not audit %s: %v\n", binaryPath, err)
+			}
+			continue
+		}
+		if r == nil {
+			continue
+		}
+		found++
+		if verbosityLevel >= silentVerbosityWithErrors {
+			fmt.Printf("%s%s%s: %s\n", yellowColor, parseBinaryEntry(program, false), resetColor, r)
+		}
+	}
+
+	if found > 0 {
+		return errAuditFailed.New("%d of %d installed binaries were revoked", found, len(programs))
+	}
+	if verbosityLevel >= normalVerbosity {
+		fmt.Printf("Audited %d binaries, none of them were revoked\n", len(programs))
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	errRepoAuth = errs.Class("repository auth error")
+)
+
+const (
+	authBearer = "bearer"
+	authBasic  = "basic"
+	authNetrc  = "netrc"
+)
+
+type repoAuth struct {
+	Type         string   `yaml:"type" description:"One of: bearer, basic, netrc."`
+	Token        string   `yaml:"token,omitempty" description:"Bearer token."`
+	TokenFile    string   `yaml:"tokenFile,omitempty" description:"File holding the bearer token, or the password for basic auth."`
+	Username     string   `yaml:"username,omitempty"`
+	Password     string   `yaml:"password,omitempty"`
+	NetrcFile    string   `yaml:"netrcFile,omitempty" description:"Defaults to $NETRC, or ~/.netrc."`
+	AllowedHosts []string `yaml:"allowedHosts,omitempty" description:"Hosts the credentials may be sent to (*.example.com is allowed). Defaults to the hosts of the URL and fallbackURLs."`
+}
+
+// allowedHosts returns the hosts that the credentials of repo may be sent to
+func (a *repoAuth) allowedHosts(repo *repository) []string {
+	if len(a.AllowedHosts) > 0 {
+		return a.AllowedHosts
+	}
+	var hosts []string
+	for _, u := range append([]string{repo.URL}, repo.FallbackURLs...) {
+		if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+			hosts = append(hosts, parsed.Host)
+		}
+	}
+	return hosts
+}
+
+func hostAllowed(allowed []string, u *url.URL) bool {
+	for _, host := range allowed {
+		host = strings.ToLower(host)
+		switch {
+		case host == strings.ToLower(u.Host), host == strings.ToLower(u.Hostname()):
+			return true
+		case strings.HasPrefix(host, "*.") && strings.HasSuffix(strings.ToLower(u.Hostname()), host[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopback reports whether host, which may carry a port, is this machine. Plain HTTP doesn't leave it
+func isLoopback(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errRepoAuth.Wrap(err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (a *repoAuth) netrcFile() string {
+	if a.NetrcFile != "" {
+		return a.NetrcFile
+	}
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcCredentials looks host up in the netrc file at path, falling back to its "default" entry
+func netrcCredentials(path, host string) (login, password string, found bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", false, errRepoAuth.Wrap(err)
+	}
+	defer file.Close()
+
+	var (
+		machine, pendingLogin, pendingPassword string
+		inEntry, isDefault, inMacro            bool
+		defLogin, defPassword                  string
+		haveDefault                            bool
+	)
+	finish := func() {
+		if !inEntry {
+			return
+		}
+		if isDefault {
+			defLogin, defPassword, haveDefault = pendingLogin, pendingPassword, true
+		} else if strings.EqualFold(machine, host) && !found {
+			login, password, found = pendingLogin, pendingPassword, true
+		}
+		inEntry, isDefault, machine, pendingLogin, pendingPassword = false, false, "", "", ""
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// Macro definitions end with an empty line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			next := func() string {
+				if i+1 < len(fields) {
+					i++
+					return fields[i]
+				}
+				return ""
+			}
+			switch fields[i] {
+			case "machine":
+				finish()
+				inEntry, machine = true, next()
+			case "default":
+				finish()
+				inEntry, isDefault = true, true
+			case "login":
+				pendingLogin = next()
+			case "password":
+				pendingPassword = next()
+			case "account":
+				next()
+			case "macdef":
+				finish()
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	finish()
+	if err := scanner.Err(); err != nil {
+		return "", "", false, errRepoAuth.Wrap(err)
+	}
+
+	if !found && haveDefault {
+		return defLogin, defPassword, true, nil
+	}
+	return login, password, found, nil
+}
+
+// authorization returns the value of the Authorization header for a request to u, or "" if there is none
+func (a *repoAuth) authorization(u *url.URL) (string, error) {
+	switch a.Type {
+	case authBearer:
+		token := a.Token
+		if a.TokenFile != "" {
+			var err error
+			if token, err = readSecretFile(a.TokenFile); err != nil {
+				return "", err
+			}
+		}
+		return "Bearer " + token, nil
+	case authBasic:
+		password := a.Password
+		if a.TokenFile != "" {
+			var err error
+			if password, err = readSecretFile(a.TokenFile); err != nil {
+				return "", err
+			}
+		}
+		req := http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(a.Username, password)
+		return req.Header.Get("Authorization"), nil
+	case authNetrc:
+		login, password, found, err := netrcCredentials(a.netrcFile(), u.Hostname())
+		if err != nil || !found {
+			return "", err
+		}
+		req := http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(login, password)
+		return req.Header.Get("Authorization"), nil
+	}
+	return "", nil
+}
+
+// validateRepoAuth checks the auth sections of every repository
+func validateRepoAuth(cfg *config) error {
+	for _, repo := range cfg.Repositories {
+		a := repo.Auth
+		if a == nil {
+			continue
+		}
+		switch a.Type {
+		case authBearer:
+			if a.Token == "" && a.TokenFile == "" {
+				return errRepoAuth.New("%s: bearer auth needs a token or a tokenFile", repo.URL)
+			}
+		case authBasic:
+			if a.Username == "" {
+				return errRepoAuth.New("%s: basic auth needs a username", repo.URL)
+			}
+		case authNetrc:
+		default:
+			return errRepoAuth.New("%s: invalid auth type %q, must be one of: %s, %s, %s", repo.URL, a.Type, authBearer, authBasic, authNetrc)
+		}
+		if len(a.allowedHosts(&repo)) == 0 {
+			return errRepoAuth.New("%s: auth needs allowedHosts, as the repository has no URL with a host", repo.URL)
+		}
+	}
+	return nil
+}
+
+// authTransport authenticates the requests made on behalf of a repository, but only those that go to
+// its allowed hosts over HTTPS (or plain HTTP on the loopback interface). Redirects elsewhere are sent
+// without credentials
+type authTransport struct {
+	auth    *repoAuth
+	allowed []string
+	next    http.RoundTripper
+}
+
+func newAuthTransport(repo *repository, next http.RoundTripper) *authTransport {
+	return &authTransport{auth: repo.Auth, allowed: repo.Auth.allowedHosts(repo), next: next}
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" || !hostAllowed(t.allowed, req.URL) || (req.URL.Scheme != "https" && !isLoopback(req.URL.Host)) {
+		return t.next.RoundTrip(req)
+	}
+	authorization, err := t.auth.authorization(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", req.URL.Host, err)
+	}
+	if authorization == "" {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", authorization)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// authRecorder records the Authorization header of the requests a test server gets, by path
+type authRecorder struct {
+	mu   sync.Mutex
+	seen map[string]string
+}
+
+func (a *authRecorder) record(r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.seen == nil {
+		a.seen = make(map[string]string)
+	}
+	a.seen[r.URL.Path] = r.Header.Get("Authorization")
+}
+
+func (a *authRecorder) authorization(path string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.seen[path]
+}
+
+func TestAuthStaysOnAllowedHosts(t *testing.T) {
+	var repoAuths, foreignAuths authRecorder
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		foreignAuths.record(r)
+	}))
+	defer foreign.Close()
+	repoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repoAuths.record(r)
+		if r.URL.Path == "/bin" {
+			http.Redirect(w, r, foreign.URL+"/bin", http.StatusFound)
+		}
+	}))
+	defer repoServer.Close()
+
+	tests := []struct {
+		name        string
+		allowed     []string
+		foreignAuth bool
+	}{
+		{"redirect to another host", nil, false},
+		{"redirect to an allowed host", []string{strings.TrimPrefix(foreign.URL, "http://")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &repository{URL: repoServer.URL + "/index.json", Auth: &repoAuth{Type: authBearer, Token: "s3cret"}}
+			if tt.allowed != nil {
+				repo.Auth.AllowedHosts = append(tt.allowed, strings.TrimPrefix(repoServer.URL, "http://"))
+			}
+			client := &http.Client{Transport: newAuthTransport(repo, http.DefaultTransport)}
+
+			for _, path := range []string{"/index.json", "/bin"} {
+				resp, err := client.Get(repoServer.URL + path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+			for _, path := range []string{"/index.json", "/bin"} {
+				if got := repoAuths.authorization(path); got != "Bearer s3cret" {
+					t.Errorf("the repository got %q for %s, want its token", got, path)
+				}
+			}
+			if got := foreignAuths.authorization("/bin"); (got != "") != tt.foreignAuth {
+				t.Errorf("the host redirected to got %q", got)
+			}
+		})
+	}
+}
+
+// recordingTransport answers every request itself, keeping the last one
+type recordingTransport struct {
+	last *http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.last = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestAuthNotSentOverPlainHTTP(t *testing.T) {
+	tests := []struct {
+		url      string
+		withAuth bool
+	}{
+		{"https://example.com/index.json", true},
+		{"http://example.com/index.json", false},
+		{"https://mirror.example.net/index.json", false},
+		{"http://127.0.0.1:8080/index.json", true},
+		{"http://localhost:8080/index.json", true},
+	}
+	repo := &repository{
+		URL:  "https://example.com/index.json",
+		Auth: &repoAuth{Type: authBasic, Username: "user", Password: "pass", AllowedHosts: []string{"example.com", "127.0.0.1", "localhost"}},
+	}
+	for _, tt := range tests {
+		next := &recordingTransport{}
+		client := &http.Client{Transport: newAuthTransport(repo, next)}
+		resp, err := client.Get(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := next.last.Header.Get("Authorization"); (got != "") != tt.withAuth {
+			t.Errorf("%s: sent %q, want credentials: %v", tt.url, got, tt.withAuth)
+		}
+	}
+}
+
+func TestNetrcAuth(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	content := `machine example.com login alice password wonderland
+macdef init
+	machine 127.0.0.1 login macro password ignored
+
+machine 127.0.0.1
+	login bob
+	password builder
+default login anonymous password guest
+`
+	if err := os.WriteFile(netrc, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host            string
+		login, password string
+	}{
+		{"example.com", "alice", "wonderland"},
+		{"EXAMPLE.com", "alice", "wonderland"},
+		{"127.0.0.1", "bob", "builder"},
+		{"other.example.org", "anonymous", "guest"},
+	}
+	for _, tt := range tests {
+		login, password, found, err := netrcCredentials(netrc, tt.host)
+		if err != nil || !found || login != tt.login || password != tt.password {
+			t.Errorf("netrcCredentials(%s) = %q, %q, %v, %v, want %q, %q", tt.host, login, password, found, err, tt.login, tt.password)
+		}
+	}
+
+	var auths authRecorder
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths.record(r)
+	}))
+	defer srv.Close()
+	repo := &repository{URL: srv.URL + "/index.json", Auth: &repoAuth{Type: authNetrc, NetrcFile: netrc}}
+	client := &http.Client{Transport: newAuthTransport(repo, http.DefaultTransport)}
+	resp, err := client.Get(repo.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := http.Request{Header: make(http.Header)}
+	want.SetBasicAuth("bob", "builder")
+	if got := auths.authorization("/index.json"); got != want.Header.Get("Authorization") {
+		t.Errorf("sent %q, want the credentials of 127.0.0.1 in the netrc file", got)
+	}
+}
@@ -24,32 +24,75 @@ var (
 	errConfigFileAccess = errs.Class("config file access error")
 	errCommandExecution = errs.Class("command execution error")
 	errSplitArgs        = errs.Class("split args error")
+	errInvalidPolicy    = errs.Class("invalid policy")
 	arch                = runtime.GOARCH + "_" + runtime.GOOS
 )
 
+const (
+	policyStrict = "strict"
+	policyWarn   = "warn"
+	policyOff    = "off"
+	// --------------------------------
+	signatureRequired = "required"
+	signatureOptional = "optional"
+	signatureNone     = "none"
+)
+
 type repository struct {
-	Name         string            `yaml:"Name,omitempty"`
-	URL          string            `yaml:"URL" description:"URL of the repository."`
-	PubKeys      map[string]string `yaml:"pubKeys" description:"URLs to the public keys for signature verification."`
-	SyncInterval time.Duration     `yaml:"syncInterval" description:"Interval for syncing this repository."`
-	FallbackURLs []string          `yaml:"fallbackURLs,omitempty" description:"Fallback URLs for the repository."`
+	Name                  string            `yaml:"Name,omitempty"`
+	URL                   string            `yaml:"URL" description:"URL of the repository."`
+	PubKeys               map[string]string `yaml:"pubKeys" description:"URLs to the public keys for signature verification."`
+	SyncInterval          time.Duration     `yaml:"syncInterval" description:"Interval for syncing this repository."`
+	FallbackURLs          []string          `yaml:"fallbackURLs,omitempty" description:"Fallback URLs for the repository."`
+	ChecksumPolicy        string            `yaml:"checksumPolicy,omitempty" description:"Checksum verification policy for this repository, overrides the global one."`
+	RequireIndexSignature bool              `yaml:"requireIndexSignature,omitempty" description:"Only accept index files that carry a valid detached signature (<url>.sig)."`
+	FreshnessPolicy       string            `yaml:"freshnessPolicy,omitempty" description:"Check the index against its timestamp document (<index>.timestamp.json), and refuse or warn about expired indexes (strict, warn, off)."`
+	SignaturePolicy       string            `yaml:"signaturePolicy,omitempty" description:"Whether binaries of this repository must be signed (required, optional, none). Defaults to optional."`
+	PinnedKeys            map[string]string `yaml:"pinnedKeys,omitempty" description:"Key IDs (or complete minisign public keys) that the keys in pubKeys must match."`
+	Auth                  *repoAuth         `yaml:"auth,omitempty" description:"Credentials for this repository (bearer, basic or netrc), only sent to its allowed hosts, over HTTPS unless they are local."`
+	Network               *networkConfig    `yaml:"network,omitempty" description:"Network settings for this repository, overriding the global ones."`
+	RevocationURL         string            `yaml:"revocationURL,omitempty" description:"URL of the list of binaries revoked by this repository, signed like its index. Defaults to <index>.revocations, if the repository publishes one."`
+	Required              bool              `yaml:"required,omitempty" description:"Fail when this repository can't be fetched, instead of falling back to its cached index or skipping it."`
+	Priority              int               `yaml:"priority,omitempty" description:"When several repositories provide a binary, the one with the highest priority is picked. Defaults to 0."`
+	Enabled               *bool             `yaml:"enabled,omitempty" description:"Whether binaries are taken from this repository at all. Defaults to true."`
+	revocations           *revocationList
+	forceSync             bool // download its files again, without asking whether they changed
+	cachedOnly            bool // use the cached copies of its files, however old they are, without fetching them
+	optional              bool // the files being fetched may not be published, their absence is not warned about
+}
+
+func (r repository) enabled() bool {
+	return r.Enabled == nil || *r.Enabled
 }
 
 type config struct {
-	Repositories        []repository `yaml:"Repositories" env:"DBIN_REPO_URLS" description:"List of repositories to fetch binaries from."`
-	InstallDir          string       `yaml:"InstallDir" env:"DBIN_INSTALL_DIR XDG_BIN_HOME" description:"Directory where binaries will be installed."`
-	CacheDir            string       `yaml:"CacheDir" env:"DBIN_CACHE_DIR" description:"Directory where cached binaries will be stored."`
-	LicenseDir          string       `yaml:"LicenseDir" env:"DBIN_LICENSE_DIR" description:"Directory where license files will be stored."`
-	CreateLicenses      bool         `yaml:"CreateLicenses" env:"DBIN_CREATE_LICENSES" description:"Enable saving of license files from OCI downloads."`
-	Limit               uint         `yaml:"SearchResultsLimit" env:"DBIN_SEARCH_LIMIT" description:"Limit the number of search results displayed."`
-	ProgressbarStyle    int          `yaml:"PbarStyle,omitempty" env:"DBIN_PB_STYLE" description:"Style of the progress bar."`
-	DisableTruncation   bool         `yaml:"Truncation" env:"DBIN_NOTRUNCATION" description:"Disable truncation of output."`
-	RetakeOwnership     bool         `yaml:"RetakeOwnership" env:"DBIN_REOWN" description:"Retake ownership of installed binaries."`
-	UseIntegrationHooks bool         `yaml:"IntegrationHooks" env:"DBIN_USEHOOKS" description:"Use integration hooks for binaries."`
-	DisableProgressbar  bool         `yaml:"DisablePbar,omitempty" env:"DBIN_NOPBAR" description:"Disable the progress bar."`
-	NoConfig            bool         `yaml:"-" env:"DBIN_NOCONFIG" description:"Disable configuration file usage."`
-	ProgressbarFIFO     bool         `yaml:"-" env:"DBIN_PB_FIFO" description:"Use FIFO for progress bar."`
-	Hooks               hooks        `yaml:"Hooks,omitempty"`
+	Repositories         []repository  `yaml:"Repositories" env:"DBIN_REPO_URLS" description:"List of repositories to fetch binaries from."`
+	InstallDir           string        `yaml:"InstallDir" env:"DBIN_INSTALL_DIR XDG_BIN_HOME" description:"Directory where binaries will be installed."`
+	CacheDir             string        `yaml:"CacheDir" env:"DBIN_CACHE_DIR" description:"Directory where cached binaries will be stored."`
+	LicenseDir           string        `yaml:"LicenseDir" env:"DBIN_LICENSE_DIR" description:"Directory where license files will be stored."`
+	TrustStore           string        `yaml:"TrustStore" env:"DBIN_TRUST_STORE" description:"File where trusted public keys are recorded."`
+	CreateLicenses       bool          `yaml:"CreateLicenses" env:"DBIN_CREATE_LICENSES" description:"Enable saving of license files from OCI downloads."`
+	Limit                uint          `yaml:"SearchResultsLimit" env:"DBIN_SEARCH_LIMIT" description:"Limit the number of search results displayed."`
+	ProgressbarStyle     int           `yaml:"PbarStyle,omitempty" env:"DBIN_PB_STYLE" description:"Style of the progress bar."`
+	DisableTruncation    bool          `yaml:"Truncation" env:"DBIN_NOTRUNCATION" description:"Disable truncation of output."`
+	RetakeOwnership      bool          `yaml:"RetakeOwnership" env:"DBIN_REOWN" description:"Retake ownership of installed binaries."`
+	UseIntegrationHooks  bool          `yaml:"IntegrationHooks" env:"DBIN_USEHOOKS" description:"Use integration hooks for binaries."`
+	DisableProgressbar   bool          `yaml:"DisablePbar,omitempty" env:"DBIN_NOPBAR" description:"Disable the progress bar."`
+	ChecksumPolicy       string        `yaml:"ChecksumPolicy" env:"DBIN_CHECKSUM_POLICY" description:"Checksum verification policy for downloads (strict, warn, off)."`
+	AllowUnverified      bool          `yaml:"AllowUnverified" env:"DBIN_ALLOW_UNVERIFIED" description:"Allow installing binaries that carry no checksum (e.g: plain URLs)."`
+	MaxParallelDownloads int           `yaml:"MaxParallelDownloads" env:"DBIN_MAX_PARALLEL_DOWNLOADS" description:"Maximum number of downloads running at once (0 means no limit)."`
+	MaxDownloadsPerHost  int           `yaml:"MaxDownloadsPerHost" env:"DBIN_MAX_DOWNLOADS_PER_HOST" description:"Maximum number of downloads running at once against a single host (0 means no limit)."`
+	RetryAttempts        int           `yaml:"RetryAttempts" env:"DBIN_RETRY_ATTEMPTS" description:"How many times a request that failed for a transient reason is attempted."`
+	DownloadSegments     int           `yaml:"DownloadSegments" env:"DBIN_DOWNLOAD_SEGMENTS" description:"Number of segments large files are split into and fetched concurrently, when the server supports ranges (1 disables it)."`
+	MinSegmentSize       int64         `yaml:"MinSegmentSize" env:"DBIN_MIN_SEGMENT_SIZE" description:"Minimum size of a segment, in bytes. Files smaller than two segments are fetched as a single stream."`
+	Platform             string        `yaml:"Platform,omitempty" env:"DBIN_PLATFORM" description:"Platform picked from multi-arch OCI images (arch, os/arch or os/arch/variant). Defaults to the host's."`
+	RegistryTokenHosts   []string      `yaml:"RegistryTokenHosts,omitempty" description:"Hosts besides the registry itself that registries may send dbin to for a token with your credentials (*.example.com is allowed). auth.docker.io is allowed for Docker Hub."`
+	NoConfig             bool          `yaml:"-" env:"DBIN_NOCONFIG" description:"Disable configuration file usage."`
+	ProgressbarFIFO      bool          `yaml:"-" env:"DBIN_PB_FIFO" description:"Use FIFO for progress bar."`
+	Network              networkConfig `yaml:"Network,omitempty" description:"Proxy, timeouts, CA certificates and headers used for every request."`
+	Preferences          preferences   `yaml:"Preferences,omitempty" description:"Which variants of a package are picked, when several match a binary."`
+	Hooks                hooks         `yaml:"Hooks,omitempty"`
+	indexExpiry          *cacheExpiry  // when the index cache built by this run has to be rebuilt, see indexCache.go
 }
 
 type hooks struct {
@@ -206,6 +249,9 @@ func loadConfig() (*config, error) {
 	if nocfg, ok := os.LookupEnv("DBIN_NOCONFIG"); ok && (nocfg == "1" || strings.ToLower(nocfg) == "true" || nocfg == "yes") {
 		cfg.NoConfig = true
 		overrideWithEnv(&cfg)
+		if err := validateConfig(&cfg); err != nil {
+			return nil, errConfigLoad.Wrap(err)
+		}
 		return &cfg, nil
 	}
 
@@ -227,7 +273,7 @@ func loadConfig() (*config, error) {
 	for v := version - 0.1; v >= version-0.3; v -= 0.1 {
 		main := fmt.Sprintf("https://d.xplshn.com.ar/misc/cmd/%.1f/%s.nlite.cbor.zst", v, arch)
 		fallback := fmt.Sprintf("https://github.com/xplshn/dbin-metadata/raw/refs/heads/master/misc/cmd/%.1f/%s.nlite.cbor.zst", v, arch)
-	
+
 		for _, repo := range cfg.Repositories {
 			if repo.URL == main {
 				fmt.Printf("Warning: One of your repository URLs points to version %.1f, which may be outdated. Current version is %.1f\n", v, version)
@@ -242,9 +288,59 @@ func loadConfig() (*config, error) {
 
 	overrideWithEnv(&cfg)
 
+	if err := validateConfig(&cfg); err != nil {
+		return nil, errConfigLoad.Wrap(err)
+	}
+
 	return &cfg, nil
 }
 
+func validatePolicy(field, value string, allowed ...string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return errInvalidPolicy.New("%s: %q is not one of %s", field, value, strings.Join(allowed, ", "))
+}
+
+func validateConfig(cfg *config) error {
+	if err := validatePolicies(cfg); err != nil {
+		return err
+	}
+	if err := validateNetworkConfig(cfg); err != nil {
+		return err
+	}
+	if err := validatePreferences(cfg); err != nil {
+		return err
+	}
+	return validateRepoAuth(cfg)
+}
+
+func validatePolicies(cfg *config) error {
+	if err := validatePolicy("ChecksumPolicy", cfg.ChecksumPolicy, policyStrict, policyWarn, policyOff); err != nil {
+		return err
+	}
+	for _, repo := range cfg.Repositories {
+		if repo.ChecksumPolicy != "" {
+			if err := validatePolicy("checksumPolicy of "+repo.URL, repo.ChecksumPolicy, policyStrict, policyWarn, policyOff); err != nil {
+				return err
+			}
+		}
+		if repo.FreshnessPolicy != "" {
+			if err := validatePolicy("freshnessPolicy of "+repo.URL, repo.FreshnessPolicy, policyStrict, policyWarn, policyOff); err != nil {
+				return err
+			}
+		}
+		if repo.SignaturePolicy != "" {
+			if err := validatePolicy("signaturePolicy of "+repo.URL, repo.SignaturePolicy, signatureRequired, signatureOptional, signatureNone); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func loadYAML(filePath string, cfg *config) error {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -310,6 +406,7 @@ func setDefaultValues(config *config) {
 	config.InstallDir = filepath.Join(xdg.BinHome)
 	config.CacheDir = filepath.Join(xdg.CacheHome, "dbin_cache")
 	config.LicenseDir = filepath.Join(xdg.ConfigHome, "dbin", "licenses")
+	config.TrustStore = filepath.Join(xdg.ConfigHome, "dbin", "trusted_keys.yaml")
 	config.CreateLicenses = true
 
 	config.Repositories = []repository{
@@ -332,6 +429,15 @@ func setDefaultValues(config *config) {
 	config.RetakeOwnership = false
 	config.ProgressbarStyle = 1
 	config.DisableProgressbar = false
+	config.ChecksumPolicy = policyStrict
+	config.AllowUnverified = false
+	config.MaxParallelDownloads = 8
+	config.MaxDownloadsPerHost = 4
+	config.RetryAttempts = 4
+	config.DownloadSegments = 4
+	config.MinSegmentSize = 16 << 20
+	config.Network.ConnectTimeout = 30 * time.Second
+	config.Network.ReadTimeout = time.Minute
 	config.NoConfig = false
 }
 
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zeebo/blake3"
+)
+
+const (
+	digestB3sum  = "B3SUM"
+	digestSHA256 = "SHA256"
+	// digestOCIBlob is recorded as verified when the content of an OCI blob matched the digest it is addressed by
+	digestOCIBlob = "OCI"
+)
+
+// digester hashes a stream with every algorithm declared by a bEntry, in a single pass
+type digester struct {
+	algorithms []string
+	hashes     map[string]hash.Hash
+}
+
+// newDigester always includes BLAKE3, so that the hash of partial downloads can be checkpointed
+func newDigester(bEntry *binaryEntry) *digester {
+	d := &digester{
+		algorithms: []string{digestB3sum},
+		hashes:     map[string]hash.Hash{digestB3sum: blake3.New()},
+	}
+	if _, ok := expectedDigests(bEntry)[digestSHA256]; ok || strings.HasPrefix(bEntry.blobDigest, "sha256:") {
+		d.algorithms = append(d.algorithms, digestSHA256)
+		d.hashes[digestSHA256] = sha256.New()
+	}
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+func (d *digester) sum(algorithm string) string {
+	if h, ok := d.hashes[algorithm]; ok {
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	return ""
+}
+
+// fileDigests hashes the file at path with every algorithm that dbin knows of, in a single pass
+func fileDigests(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errFileAccess.Wrap(err)
+	}
+	defer file.Close()
+
+	d := &digester{
+		algorithms: []string{digestB3sum, digestSHA256},
+		hashes:     map[string]hash.Hash{digestB3sum: blake3.New(), digestSHA256: sha256.New()},
+	}
+	if _, err := io.Copy(d, file); err != nil {
+		return nil, errFileAccess.Wrap(err)
+	}
+	return map[string]string{digestB3sum: d.sum(digestB3sum), digestSHA256: d.sum(digestSHA256)}, nil
+}
+
+// expectedDigests returns the digests that the bEntry declares, by algorithm
+func expectedDigests(bEntry *binaryEntry) map[string]string {
+	digests := make(map[string]string, 2)
+	if bEntry.Bsum != "" && bEntry.Bsum != "!no_check" {
+		digests[digestB3sum] = strings.ToLower(bEntry.Bsum)
+	}
+	if bEntry.Shasum != "" && bEntry.Shasum != "!no_check" {
+		digests[digestSHA256] = strings.ToLower(strings.TrimPrefix(bEntry.Shasum, "sha256:"))
+	}
+	return digests
+}
+
+// verifyBlobDigest checks an OCI blob against the digest it was requested by. Unlike the
+// digests of the index, this one is enforced whatever the checksum policy is
+func verifyBlobDigest(hash *digester, bEntry *binaryEntry, tempFile string) error {
+	if bEntry.blobDigest == "" {
+		return nil
+	}
+
+	algorithm, want, _ := strings.Cut(bEntry.blobDigest, ":")
+	if algorithm != "sha256" {
+		os.Remove(tempFile)
+		return errChecksumMismatch.New("%s: unsupported blob digest %s", bEntry.Name, bEntry.blobDigest)
+	}
+	if got := hash.sum(digestSHA256); got != strings.ToLower(want) {
+		os.Remove(tempFile)
+		return errChecksumMismatch.New("%s: blob expected %s, got sha256:%s", bEntry.Name, bEntry.blobDigest, got)
+	}
+
+	bEntry.verifiedDigests = append(bEntry.verifiedDigests, digestOCIBlob)
+	return nil
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -14,98 +16,84 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/goccy/go-json"
 	"github.com/hedzr/progressbar"
 	"github.com/jedisct1/go-minisign"
 	"github.com/pkg/xattr"
-	"github.com/zeebo/blake3"
 	"github.com/zeebo/errs"
 )
 
 var (
 	errDownloadFailed   = errs.Class("download failed")
 	errSignatureVerify  = errs.Class("signature verification failed")
+	errSignatureMissing = errs.Class("signature unavailable")
 	errChecksumMismatch = errs.Class("checksum mismatch")
+	errUnverified       = errs.Class("refusing unverified download")
 	errOCIReference     = errs.Class("invalid OCI reference")
 	errAuthToken        = errs.Class("failed to get auth token")
 	errManifestDownload = errs.Class("failed to download manifest")
 	errOCILayerDownload = errs.Class("failed to download OCI layer")
 )
 
-type ociXAttrMeta struct {
-	Offset int64  `json:"offset"`
-	Digest string `json:"digest"`
-}
-
-func getOCIMeta(path string) (ociXAttrMeta, error) {
-	var meta ociXAttrMeta
-	raw, err := xattr.Get(path, "user.dbin.ocichunk")
-	if err != nil {
-		return meta, err
-	}
-	return meta, json.Unmarshal(raw, &meta)
-}
-
-func setOCIMeta(path string, offset int64, digest string) error {
-	meta := ociXAttrMeta{Offset: offset, Digest: digest}
-	raw, err := json.Marshal(meta)
-	if err != nil {
-		return err
-	}
-	xattr.Set(path, "user.dbin.ocichunk", raw)
-	return nil
-}
-
-func downloadWithProgress(ctx context.Context, bar progressbar.PB, resp *http.Response, destination string, bEntry *binaryEntry, isOCI bool, lastModified string, providedOffset int64) error {
+// downloadWithProgress writes the body of resp to the .tmp file of destination, after the meta.Offset
+// bytes that are already there, and moves it into place once it has been verified
+func downloadWithProgress(ctx context.Context, bar progressbar.PB, resp *http.Response, destination string, bEntry *binaryEntry, cfg *config, meta resumeMeta) error {
 	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
 		return errDownloadFailed.Wrap(err)
 	}
 
 	tempFile := destination + ".tmp"
-	resumeOffset := providedOffset
-	if isOCI {
-		if meta, err := getOCIMeta(tempFile); err == nil {
-			resumeOffset = meta.Offset
-		}
-	}
-
-	out, err := openOrCreateFile(tempFile, resumeOffset)
+	out, err := openOrCreateFile(tempFile, meta.Offset)
 	if err != nil {
 		return errDownloadFailed.Wrap(err)
 	}
 	defer out.Close()
+	xattr.Remove(tempFile, segmentsXAttr)
 
-	if !isOCI && lastModified != "" {
-		xattr.Set(tempFile, "user.dbin.lastmod", []byte(lastModified))
-	}
-
-	hash, err := initializeHash(tempFile, resumeOffset)
+	hash, err := initializeHash(tempFile, meta.Offset, bEntry)
 	if err != nil {
 		return errDownloadFailed.Wrap(err)
 	}
 
-	writer := setupWriter(out, hash, bar, resp, resumeOffset)
+	writer := setupWriter(out, hash, bar, resp, meta.Offset)
 
-	_, err = copyWithInterruption(ctx, writer, resp.Body, hash, tempFile, isOCI, resumeOffset)
+	_, err = copyWithInterruption(ctx, writer, resp.Body, hash, tempFile, meta)
 	if err != nil {
 		return err
 	}
 
-	if err := cleanupMetadata(tempFile, isOCI); err != nil {
-		return errDownloadFailed.Wrap(err)
+	xattr.Remove(tempFile, resumeXAttr)
+	return finishDownload(hash, tempFile, destination, bEntry, cfg)
+}
+
+// finishDownload verifies the complete .tmp file (hashed into hash) and moves it to destination
+func finishDownload(hash *digester, tempFile, destination string, bEntry *binaryEntry, cfg *config) error {
+	if err := verifyChecksum(hash, bEntry, tempFile, cfg); err != nil {
+		return err
 	}
 
-	if err := verifyChecksum(hash, bEntry, tempFile); err != nil {
+	if err := verifyBlobDigest(hash, bEntry, tempFile); err != nil {
 		return err
 	}
 
+	digests := map[string]string{digestB3sum: hash.sum(digestB3sum), digestSHA256: hash.sum(digestSHA256)}
+	if r := bEntry.Repository.config().revocations.revocationOf(bEntry.PkgID, digests); r != nil {
+		os.Remove(tempFile)
+		return errRevoked.New("%s: %s", parseBinaryEntry(*bEntry, false), r)
+	}
+
 	if err := validateFileType(tempFile); err != nil {
 		return err
 	}
 
+	if err := verifyDownloadSignature(tempFile, bEntry, cfg); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+
 	if err := os.Rename(tempFile, destination); err != nil {
 		return errDownloadFailed.Wrap(err)
 	}
+	bEntry.localBsum = hash.sum(digestB3sum)
 
 	return os.Chmod(destination, 0755)
 }
@@ -116,6 +104,11 @@ func openOrCreateFile(path string, offset int64) (*os.File, error) {
 		if err != nil {
 			return nil, err
 		}
+		// Whatever was written after the last checkpoint is downloaded again
+		if err := out.Truncate(offset); err != nil {
+			out.Close()
+			return nil, err
+		}
 		if _, err := out.Seek(offset, io.SeekStart); err != nil {
 			out.Close()
 			return nil, err
@@ -125,8 +118,8 @@ func openOrCreateFile(path string, offset int64) (*os.File, error) {
 	return os.Create(path)
 }
 
-func initializeHash(tempFile string, resumeOffset int64) (*blake3.Hasher, error) {
-	hash := blake3.New()
+func initializeHash(tempFile string, resumeOffset int64, bEntry *binaryEntry) (*digester, error) {
+	hash := newDigester(bEntry)
 	if resumeOffset > 0 {
 		rf, err := os.Open(tempFile)
 		if err != nil {
@@ -141,7 +134,7 @@ func initializeHash(tempFile string, resumeOffset int64) (*blake3.Hasher, error)
 	return hash, nil
 }
 
-func setupWriter(out *os.File, hash *blake3.Hasher, bar progressbar.PB, resp *http.Response, resumeOffset int64) io.Writer {
+func setupWriter(out *os.File, hash *digester, bar progressbar.PB, resp *http.Response, resumeOffset int64) io.Writer {
 	if bar != nil {
 		writer := io.MultiWriter(out, hash, bar)
 		bar.UpdateRange(0, resp.ContentLength+resumeOffset)
@@ -151,22 +144,27 @@ func setupWriter(out *os.File, hash *blake3.Hasher, bar progressbar.PB, resp *ht
 	return io.MultiWriter(out, hash)
 }
 
-func copyWithInterruption(ctx context.Context, writer io.Writer, reader io.Reader, hash *blake3.Hasher, tempFile string, isOCI bool, startOffset int64) (int64, error) {
+// copyWithInterruption copies the download into writer, checkpointing its progress in the xattrs
+// of tempFile as it goes, and when it is interrupted
+func copyWithInterruption(ctx context.Context, writer io.Writer, reader io.Reader, hash *digester, tempFile string, meta resumeMeta) (int64, error) {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 	defer signal.Stop(sigCh)
 
-	written := startOffset
-	buf := make([]byte, 64*1024)
+	written := meta.Offset
+	checkpoint := func() error {
+		meta.Offset, meta.Digest = written, hash.sum(digestB3sum)
+		return meta.save(tempFile)
+	}
 
+	buf := make([]byte, 64*1024)
 	for {
 		select {
 		case <-ctx.Done():
+			checkpoint()
 			return written, ctx.Err()
 		case <-sigCh:
-			if isOCI {
-				setOCIMeta(tempFile, written, hex.EncodeToString(hash.Sum(nil)))
-			}
+			checkpoint()
 			os.Exit(130)
 		default:
 		}
@@ -177,8 +175,8 @@ func copyWithInterruption(ctx context.Context, writer io.Writer, reader io.Reade
 				return written, errDownloadFailed.Wrap(errw)
 			}
 			written += int64(n)
-			if isOCI && written%524288 == 0 {
-				if err := setOCIMeta(tempFile, written, hex.EncodeToString(hash.Sum(nil))); err != nil {
+			if written-meta.Offset >= resumeCheckpointInterval {
+				if err := checkpoint(); err != nil {
 					return written, errDownloadFailed.Wrap(err)
 				}
 			}
@@ -188,34 +186,86 @@ func copyWithInterruption(ctx context.Context, writer io.Writer, reader io.Reade
 			break
 		}
 		if err != nil {
-			if isOCI {
-				setOCIMeta(tempFile, written, hex.EncodeToString(hash.Sum(nil)))
-			}
-			return written, errDownloadFailed.Wrap(err)
+			checkpoint()
+			return written, errDownloadFailed.Wrap(transientRequestError(ctx, err))
 		}
 	}
 
 	return written, nil
 }
 
-func cleanupMetadata(tempFile string, isOCI bool) error {
-	if isOCI {
-		xattr.Remove(tempFile, "user.dbin.ocichunk")
+// checksumPolicyOf returns the policy of the bEntry's repository, or the global one if it has none
+func checksumPolicyOf(bEntry *binaryEntry, cfg *config) string {
+	if bEntry.Repository.config().ChecksumPolicy != "" {
+		return bEntry.Repository.config().ChecksumPolicy
+	}
+	return cfg.ChecksumPolicy
+}
+
+// checkVerifiable refuses, depending on the policy, to download binaries that carry no checksum.
+// OCI references pinned to a manifest digest need none, see isPinnedOCIReference
+func checkVerifiable(bEntry *binaryEntry, cfg *config) error {
+	if len(expectedDigests(bEntry)) > 0 || isPinnedOCIReference(bEntry.DownloadURL) {
 		return nil
 	}
-	xattr.Remove(tempFile, "user.dbin.lastmod")
+
+	switch checksumPolicyOf(bEntry, cfg) {
+	case policyStrict:
+		if !cfg.AllowUnverified {
+			return errUnverified.New("%s has no checksum to be verified against. Set AllowUnverified (DBIN_ALLOW_UNVERIFIED=1) to install it anyway", bEntry.Name)
+		}
+	case policyWarn:
+		if verbosityLevel >= silentVerbosityWithErrors {
+			fmt.Fprintf(os.Stderr, "Warning: %s has no checksum, it will not be verified\n", bEntry.Name)
+		}
+	}
 	return nil
 }
 
-func verifyChecksum(hash *blake3.Hasher, bEntry *binaryEntry, destination string) error {
-	if bEntry.Bsum != "" && bEntry.Bsum != "!no_check" {
-		calculatedChecksum := hex.EncodeToString(hash.Sum(nil))
-		if calculatedChecksum != bEntry.Bsum {
-			fmt.Fprintf(os.Stderr, "expected %s, got %s\n", bEntry.Bsum, calculatedChecksum)
-			//os.Remove(destination)
-			//return errChecksumMismatch.New("expected %s, got %s", bEntry.Bsum, calculatedChecksum)
+// isPinnedOCIReference reports whether url is an OCI reference pinned to the digest of its manifest. The
+// manifest is checked against it, and the blobs against the digests it lists, so the whole chain goes back
+// to the index. A tag could point to anything the registry likes
+func isPinnedOCIReference(url string) bool {
+	reference, ok := strings.CutPrefix(url, "oci://")
+	if !ok {
+		return false
+	}
+	_, _, manifest, err := parseReference(reference)
+	return err == nil && strings.HasPrefix(manifest, "sha256:")
+}
+
+// verifyChecksum checks every digest declared by the bEntry, and records the ones that matched in bEntry.verifiedDigests
+func verifyChecksum(hash *digester, bEntry *binaryEntry, tempFile string, cfg *config) error {
+	bEntry.verifiedDigests = nil
+	policy := checksumPolicyOf(bEntry, cfg)
+	if policy == policyOff {
+		return nil
+	}
+
+	expected := expectedDigests(bEntry)
+	for _, algorithm := range hash.algorithms {
+		want, ok := expected[algorithm]
+		if !ok {
+			continue
 		}
+
+		calculatedChecksum := hash.sum(algorithm)
+		if calculatedChecksum == want {
+			bEntry.verifiedDigests = append(bEntry.verifiedDigests, algorithm)
+			continue
+		}
+
+		if policy == policyWarn {
+			if verbosityLevel >= silentVerbosityWithErrors {
+				fmt.Fprintf(os.Stderr, "Warning: %s mismatch for %s: expected %s, got %s\n", algorithm, bEntry.Name, want, calculatedChecksum)
+			}
+			continue
+		}
+
+		os.Remove(tempFile)
+		return errChecksumMismatch.New("%s: %s expected %s, got %s", bEntry.Name, algorithm, want, calculatedChecksum)
 	}
+
 	return nil
 }
 
@@ -260,20 +310,28 @@ func validateFileType(filePath string) error {
 	return errFileTypeInvalid.New("file is neither a shell script nor an ELF. Please report this at @ https://github.com/xplshn/dbin")
 }
 
-func verifySignature(binaryPath string, sigData []byte, bEntry *binaryEntry, cfg *config) error {
-	pubKeyURL := bEntry.Repository.PubKeys[bEntry.Repository.Name]
-	if pubKeyURL == "" {
+// verifyDownloadSignature checks the complete .tmp file against the signature of bEntry, before it replaces
+// whatever is installed, if bEntry is signed and its repository has a public key
+func verifyDownloadSignature(tempFile string, bEntry *binaryEntry, cfg *config) error {
+	if bEntry.signature == nil || bEntry.Repository.config().PubKeys[bEntry.Repository.Name] == "" || signaturePolicyOf(bEntry) == signatureNone {
 		return nil
 	}
 
-	pubKeyData, err := accessCachedOrFetch([]string{pubKeyURL}, bEntry.Repository.Name+".minisign", cfg, bEntry.Repository.SyncInterval)
+	sigData, err := bEntry.signature()
 	if err != nil {
-		return errSignatureVerify.Wrap(err)
+		return err
 	}
+	return verifySignature(tempFile, sigData, bEntry, cfg)
+}
 
-	pubKey, err := minisign.NewPublicKey(string(pubKeyData))
+func verifySignature(binaryPath string, sigData []byte, bEntry *binaryEntry, cfg *config) error {
+	if bEntry.Repository.config().PubKeys[bEntry.Repository.Name] == "" {
+		return nil
+	}
+
+	pubKey, err := loadPublicKey(cfg, *bEntry.Repository.config(), bEntry.Repository.Name)
 	if err != nil {
-		return errSignatureVerify.Wrap(err)
+		return errSignatureMissing.Wrap(err)
 	}
 
 	sig, err := minisign.DecodeSignature(string(sigData))
@@ -310,50 +368,68 @@ func createHTTPRequest(ctx context.Context, method, url string) (*http.Request,
 }
 
 func fetchBinaryFromURLToDest(ctx context.Context, bar progressbar.PB, bEntry *binaryEntry, destination string, cfg *config) error {
+	if err := checkRevoked(bEntry); err != nil {
+		return err
+	}
+
 	if strings.HasPrefix(bEntry.DownloadURL, "oci://") {
+		if err := checkVerifiable(bEntry, cfg); err != nil {
+			return err
+		}
+		if err := checkSignable(bEntry); err != nil {
+			return err
+		}
 		bEntry.DownloadURL = strings.TrimPrefix(bEntry.DownloadURL, "oci://")
 		return fetchOCIImage(ctx, bar, bEntry, destination, cfg)
 	}
 
-	client := &http.Client{}
-
-	// Check for signature and license file existence
-	hasSignature, hasLicense, err := httpCheckSignatureAndLicense(ctx, client, bEntry.DownloadURL)
-	if err != nil {
-		return errDownloadFailed.Wrap(err)
+	if err := checkVerifiable(bEntry, cfg); err != nil {
+		return err
 	}
 
-	resumeOffset, lastModified, err := checkPartialDownload(destination + ".tmp")
-	if err != nil {
-		return errDownloadFailed.Wrap(err)
-	}
+	client := httpClient(cfg, bEntry.Repository.repo)
 
-	// Validate resume capability
-	if err := validateResume(ctx, client, bEntry.DownloadURL); err != nil {
+	if err := checkSignable(bEntry); err != nil {
 		return err
 	}
 
-	resp, actualOffset, err := createDownloadRequest(ctx, client, bEntry.DownloadURL, resumeOffset, lastModified)
+	// Check for signature and license file existence
+	hasSignature, hasLicense, err := httpCheckSignatureAndLicense(ctx, client, bEntry.DownloadURL, signaturePolicyOf(bEntry))
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if err := downloadWithProgress(ctx, bar, resp, destination, bEntry, false, resp.Header.Get("Last-Modified"), actualOffset); err != nil {
-		return err
+	if hasSignature {
+		bEntry.signature = func() ([]byte, error) { return fetchBinarySignature(ctx, client, bEntry.DownloadURL) }
 	}
 
-	// Handle signature verification if signature exists
-	if hasSignature {
-		if err := handleSignatureVerification(bEntry, destination, cfg); err != nil {
+	// Every attempt resumes from whatever the previous ones left in the .tmp file
+	fetch := httpRangeFetcher(client, bEntry.DownloadURL)
+	err = withRetries(ctx, cfg, bEntry.Name, func() error {
+		if m := loadSegmentsMeta(destination+".tmp", bEntry.DownloadURL); m != nil {
+			return downloadSegmented(ctx, bar, nil, fetch, m, destination, bEntry, cfg)
+		}
+		meta := loadResumeMeta(destination+".tmp", bEntry.DownloadURL)
+		resp, err := createDownloadRequest(ctx, client, bEntry.DownloadURL, &meta)
+		if err != nil {
 			return err
 		}
+		defer resp.Body.Close()
+
+		return downloadFile(ctx, bar, resp, fetch, destination, bEntry, cfg, meta)
+	})
+	if err != nil {
+		return err
 	}
 
 	// Handle license file download if license exists and CreateLicenses is enabled
 	if hasLicense && cfg.CreateLicenses {
 		licenseDest := filepath.Join(cfg.LicenseDir, filepath.Base(destination)+".LICENSE")
-		licenseResp, err := http.Get(bEntry.DownloadURL + ".LICENSE")
+		licenseReq, err := createHTTPRequest(ctx, "GET", bEntry.DownloadURL+".LICENSE")
+		if err != nil {
+			return errDownloadFailed.Wrap(err)
+		}
+		licenseResp, err := client.Do(licenseReq)
 		if err != nil {
 			if verbosityLevel >= silentVerbosityWithErrors {
 				fmt.Fprintf(os.Stderr, "Warning: Failed to fetch license file for %s: %v\n", destination, err)
@@ -369,7 +445,7 @@ func fetchBinaryFromURLToDest(ctx context.Context, bar progressbar.PB, bEntry *b
 			return nil
 		}
 
-		if err := saveLicenseFile(ctx, licenseResp, licenseDest); err != nil {
+		if err := saveLicenseFile(ctx, licenseResp.Body, licenseDest); err != nil {
 			if verbosityLevel >= silentVerbosityWithErrors {
 				fmt.Fprintf(os.Stderr, "Warning: Failed to save license file for %s: %v\n", destination, err)
 			}
@@ -387,163 +463,148 @@ func fetchBinaryFromURLToDest(ctx context.Context, bar progressbar.PB, bEntry *b
 	return nil
 }
 
-func checkPartialDownload(tempFile string) (int64, string, error) {
-	fi, err := os.Stat(tempFile)
+// createDownloadRequest continues the download that meta describes if the server still has the same file,
+// and starts it over otherwise. meta is updated to describe where the body of the response goes
+func createDownloadRequest(ctx context.Context, client *http.Client, url string, meta *resumeMeta) (*http.Response, error) {
+	req, err := createHTTPRequest(ctx, "GET", url)
 	if err != nil {
-		return 0, "", nil // No partial download
-	}
-
-	resumeOffset := fi.Size()
-	var lastModified string
-	if modTimeBytes, err := xattr.Get(tempFile, "user.dbin.lastmod"); err == nil {
-		lastModified = string(modTimeBytes)
-	}
-
-	return resumeOffset, lastModified, nil
-}
-
-func validateResume(ctx context.Context, client *http.Client, url string) error {
-	req, err := createHTTPRequest(ctx, "HEAD", url)
-	if err != nil {
-		return errDownloadFailed.Wrap(err)
+		return nil, errDownloadFailed.Wrap(err)
 	}
+	setRangeHeaders(req, meta, true)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return errDownloadFailed.Wrap(err)
+		return nil, errDownloadFailed.Wrap(transientRequestError(ctx, err))
 	}
-	resp.Body.Close()
 
-	return nil
-}
-
-func createDownloadRequest(ctx context.Context, client *http.Client, url string, resumeOffset int64, lastModified string) (*http.Response, int64, error) {
-	req, err := createHTTPRequest(ctx, "GET", url)
-	if err != nil {
-		return nil, 0, errDownloadFailed.Wrap(err)
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && meta.Offset > 0 {
+		resp.Body.Close()
+		startOver(nil, meta)
+		return createDownloadRequest(ctx, client, url, meta)
+	}
+	if err := checkStatus(resp, http.StatusOK, http.StatusPartialContent); err != nil {
+		resp.Body.Close()
+		return nil, errDownloadFailed.Wrap(err)
 	}
 
-	if resumeOffset > 0 {
-		if lastModified != "" {
-			req.Header.Set("If-Range", lastModified)
+	if resp.StatusCode == http.StatusPartialContent {
+		if err := checkResumed(resp, meta); err != nil {
+			resp.Body.Close()
+			if meta.Offset == 0 {
+				return nil, errDownloadFailed.Wrap(err)
+			}
+			if verbosityLevel >= extraVerbose {
+				fmt.Fprintf(os.Stderr, "Warning: can't resume %s: %v, starting over\n", url, err)
+			}
+			startOver(nil, meta)
+			return createDownloadRequest(ctx, client, url, meta)
 		}
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeOffset))
+		return resp, nil
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, 0, errDownloadFailed.Wrap(err)
-	}
+	// The server sent the whole file, it changed or doesn't do ranges
+	startOver(resp, meta)
+	return resp, nil
+}
 
-	actualOffset := resumeOffset
-	// Reset if server sends full file instead of range
-	if resumeOffset > 0 && resp.StatusCode == http.StatusOK {
-		os.Remove(req.URL.Path + ".tmp")
-		actualOffset = 0
+// signaturePolicyOf returns the signature policy of the bEntry's repository, which defaults to "optional"
+func signaturePolicyOf(bEntry *binaryEntry) string {
+	if bEntry.Repository.config().SignaturePolicy == "" {
+		return signatureOptional
 	}
-
-	return resp, actualOffset, nil
+	return bEntry.Repository.config().SignaturePolicy
 }
 
-func handleSignatureVerification(bEntry *binaryEntry, destination string, cfg *config) error {
-	pubKeyURL := bEntry.Repository.PubKeys[bEntry.Repository.Name]
-	if pubKeyURL == "" {
-		return nil
+// checkSignable makes sure that a binary whose signature is required can be verified at all, before downloading it
+func checkSignable(bEntry *binaryEntry) error {
+	if signaturePolicyOf(bEntry) == signatureRequired && bEntry.Repository.config().PubKeys[bEntry.Repository.Name] == "" {
+		return errSignatureMissing.New("%s requires signatures, but there is no public key for %q", ternary(bEntry.Repository.config().URL != "", bEntry.Repository.config().URL, bEntry.Name), bEntry.Repository.Name)
 	}
+	return nil
+}
 
-	sigResp, err := http.Get(bEntry.DownloadURL + ".sig")
+// fetchBinarySignature downloads the .sig sidecar of url
+func fetchBinarySignature(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	sigReq, err := createHTTPRequest(ctx, "GET", url+".sig")
 	if err != nil {
-		return errSignatureVerify.Wrap(err)
+		return nil, errSignatureMissing.Wrap(err)
+	}
+	sigResp, err := client.Do(sigReq)
+	if err != nil {
+		return nil, errSignatureMissing.Wrap(err)
 	}
 	defer sigResp.Body.Close()
 
 	if sigResp.StatusCode != http.StatusOK {
-		return errSignatureVerify.New("status code %d", sigResp.StatusCode)
+		return nil, errSignatureMissing.New("%s.sig: status code %d", url, sigResp.StatusCode)
 	}
 
 	sigData, err := io.ReadAll(sigResp.Body)
 	if err != nil {
-		return errSignatureVerify.Wrap(err)
-	}
-
-	if err := verifySignature(destination, sigData, bEntry, cfg); err != nil {
-		os.Remove(destination)
-		return err
+		return nil, errSignatureMissing.Wrap(err)
 	}
-
-	return nil
+	return sigData, nil
 }
 
 func fetchOCIImage(ctx context.Context, bar progressbar.PB, bEntry *binaryEntry, destination string, cfg *config) error {
-	parts := strings.SplitN(bEntry.DownloadURL, ":", 2)
-	if len(parts) != 2 {
-		return errOCIReference.New("invalid OCI reference format")
-	}
-
-	image, tag := parts[0], parts[1]
-	registry, repository := parseImage(image)
-
-	token, err := getAuthToken(registry, repository)
+	registry, repository, reference, err := parseReference(bEntry.DownloadURL)
 	if err != nil {
 		return err
 	}
 
-	manifest, err := downloadManifest(ctx, registry, repository, tag, token)
+	oci := newOCIClient(cfg, httpClient(cfg, bEntry.Repository.repo), registry, repository)
+	manifest, err := resolveManifest(ctx, oci, reference, parsePlatform(cfg.Platform))
 	if err != nil {
 		return err
 	}
 
 	title := filepath.Base(destination)
-	binaryResp, sigResp, licenseResp, err := downloadOCILayer(ctx, registry, repository, manifest, token, title, destination+".tmp", cfg)
+	digests, err := ociLayerDigests(ctx, oci, manifest, title, cfg)
 	if err != nil {
 		return err
 	}
-	defer closeResponses(binaryResp, sigResp, licenseResp)
+	bEntry.blobDigest = digests.binary
 
-	if err := downloadWithProgress(ctx, bar, binaryResp, destination, bEntry, true, "", 0); err != nil {
+	// The signature and license are fetched before the binary, so that the download keeps to a single connection
+	var sidecars ociSidecars
+	err = withRetries(ctx, cfg, bEntry.Name, func() error {
+		sidecars, err = downloadOCISidecars(ctx, oci, digests, cfg)
 		return err
-	}
-
-	if err := handleOCISignature(bEntry, destination, cfg, sigResp); err != nil {
+	})
+	if err != nil {
 		return err
 	}
-
-	return handleOCILicense(cfg, licenseResp, title, destination)
-}
-
-func closeResponses(responses ...*http.Response) {
-	for _, resp := range responses {
-		if resp != nil {
-			resp.Body.Close()
-		}
+	if digests.signature != "" {
+		bEntry.signature = func() ([]byte, error) { return sidecars.signature, nil }
+	} else if signaturePolicyOf(bEntry) == signatureRequired {
+		return errSignatureMissing.New("the OCI manifest of %s has no signature layer", bEntry.Name)
 	}
-}
 
-func handleOCISignature(bEntry *binaryEntry, destination string, cfg *config, sigResp *http.Response) error {
-	if bEntry.Repository.PubKeys[bEntry.Repository.Name] == "" || sigResp == nil {
-		return nil
-	}
+	// Every attempt resumes from the offset recorded in the xattrs of the .tmp file
+	err = withRetries(ctx, cfg, bEntry.Name, func() error {
+		meta := loadResumeMeta(destination+".tmp", digests.binary)
+		binaryResp, err := downloadOCIBlob(ctx, oci, digests.binary, &meta)
+		if err != nil {
+			return err
+		}
+		defer binaryResp.Body.Close()
 
-	sigData, err := io.ReadAll(sigResp.Body)
+		return downloadFile(ctx, bar, binaryResp, ociRangeFetcher(oci, digests.binary), destination, bEntry, cfg, meta)
+	})
 	if err != nil {
-		return errSignatureVerify.Wrap(err)
-	}
-
-	if err := verifySignature(destination, sigData, bEntry, cfg); err != nil {
-		os.Remove(destination)
 		return err
 	}
 
-	return nil
+	return handleOCILicense(cfg, sidecars.license, title, destination)
 }
 
-func handleOCILicense(cfg *config, licenseResp *http.Response, title, destination string) error {
-	if !cfg.CreateLicenses || licenseResp == nil {
+func handleOCILicense(cfg *config, license []byte, title, destination string) error {
+	if !cfg.CreateLicenses || license == nil {
 		return nil
 	}
 
 	licenseDest := filepath.Join(cfg.LicenseDir, title+".LICENSE")
-	if err := saveLicenseFile(context.Background(), licenseResp, licenseDest); err != nil {
+	if err := saveLicenseFile(context.Background(), bytes.NewReader(license), licenseDest); err != nil {
 		if verbosityLevel >= silentVerbosityWithErrors {
 			fmt.Fprintf(os.Stderr, "Warning: Failed to save license file for %s: %v\n", title, err)
 		}
@@ -559,59 +620,61 @@ func handleOCILicense(cfg *config, licenseResp *http.Response, title, destinatio
 	return nil
 }
 
-func parseImage(image string) (string, string) {
-	parts := strings.SplitN(image, "/", 2)
-	if len(parts) == 1 {
-		return "docker.io", "library/" + parts[0]
+// parseReference splits an OCI reference (e.g: ghcr.io/org/repo:tag, localhost:5000/repo:tag, repo@sha256:...)
+// into the image and the tag or digest of its manifest. A digest wins over a tag (repo:tag@sha256:...), and the
+// tag follows the last ":" that comes after the last "/", so that registries with a port are understood
+func parseReference(reference string) (registry, repository, manifest string, err error) {
+	image, digest, pinned := strings.Cut(reference, "@")
+	if pinned {
+		if !strings.HasPrefix(digest, "sha256:") || len(digest) != len("sha256:")+64 {
+			return "", "", "", errOCIReference.New("%s: invalid digest %q", reference, digest)
+		}
+		manifest = digest
 	}
-	return parts[0], parts[1]
-}
 
-func getAuthToken(registry, repository string) (string, error) {
-	url := fmt.Sprintf("https://%s/token?service=%s&scope=repository:%s:pull", registry, registry, repository)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", errAuthToken.Wrap(err)
+	colon := strings.LastIndex(image, ":")
+	if colon > strings.LastIndex(image, "/") {
+		manifest = ternary(pinned, manifest, image[colon+1:])
+		image = image[:colon]
+	} else if !pinned {
+		return "", "", "", errOCIReference.New("%s: missing tag", reference)
 	}
-	defer resp.Body.Close()
 
-	var tokenResponse struct {
-		Token string `json:"token"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
-		return "", errAuthToken.Wrap(err)
-	}
-	return tokenResponse.Token, nil
+	registry, repository = parseImage(image)
+	return registry, repository, manifest, nil
 }
 
-func downloadManifest(ctx context.Context, registry, repository, version, token string) (map[string]any, error) {
-	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repository, version)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, errManifestDownload.Wrap(err)
+// ociReferenceAt returns the reference with its tag or digest replaced by version
+func ociReferenceAt(reference, version string) string {
+	image, _, _ := strings.Cut(reference, "@")
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		image = image[:colon]
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Accept", "application/vnd.oci.image.manifest.v1+json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, errManifestDownload.Wrap(err)
+	if strings.HasPrefix(version, "sha256:") {
+		return image + "@" + version
 	}
-	defer resp.Body.Close()
+	return image + ":" + version
+}
 
-	var manifest map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
-		return nil, errManifestDownload.Wrap(err)
+// parseImage splits the registry from the repository. Like docker, the first component is only taken
+// as a registry if it looks like a host (it has a "." or a port, or is localhost)
+func parseImage(image string) (string, string) {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 {
+		return "docker.io", "library/" + parts[0]
 	}
-	return manifest, nil
+	if !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		return "docker.io", image
+	}
+	return parts[0], parts[1]
 }
 
-func saveLicenseFile(ctx context.Context, resp *http.Response, destination string) error {
+func saveLicenseFile(ctx context.Context, body io.Reader, destination string) error {
 	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
 		return errDownloadFailed.Wrap(err)
 	}
 
-	data, err := io.ReadAll(resp.Body)
+	data, err := io.ReadAll(body)
 	if err != nil {
 		return errDownloadFailed.Wrap(err)
 	}
@@ -628,97 +691,94 @@ func saveLicenseFile(ctx context.Context, resp *http.Response, destination strin
 	return os.Chmod(destination, 0644)
 }
 
-func downloadOCILayer(ctx context.Context, registry, repository string, manifest map[string]interface{}, token, title, tmpPath string, cfg *config) (*http.Response, *http.Response, *http.Response, error) {
-	titleNoExt := strings.TrimSuffix(title, filepath.Ext(title))
-	layers, ok := manifest["layers"].([]interface{})
-	if !ok {
-		return nil, nil, nil, errOCILayerDownload.New("invalid manifest structure")
-	}
-
-	digests := findLayerDigests(layers, title, titleNoExt)
-	if digests.binary == "" {
-		return nil, nil, nil, errOCILayerDownload.New("file with title '%s' not found in manifest", title)
-	}
-
-	binaryResp, err := downloadOCIBlob(ctx, registry, repository, digests.binary, token, tmpPath)
-	if err != nil {
-		return nil, nil, nil, err
-	}
+// ociSidecars holds the signature and license of a binary stored in an OCI registry, nil if it has none
+type ociSidecars struct {
+	signature, license []byte
+}
 
-	var sigResp, licenseResp *http.Response
+// downloadOCISidecars reads the signature and, if licenses are kept, the license blobs of digests, one after the other
+func downloadOCISidecars(ctx context.Context, oci *ociClient, digests layerDigests, cfg *config) (ociSidecars, error) {
+	var (
+		sidecars ociSidecars
+		err      error
+	)
 	if digests.signature != "" {
-		sigResp, err = downloadOCIBlob(ctx, registry, repository, digests.signature, token, "")
-		if err != nil {
-			binaryResp.Body.Close()
-			return nil, nil, nil, err
+		if sidecars.signature, err = readOCIBlob(ctx, oci, digests.signature); err != nil {
+			return ociSidecars{}, err
 		}
 	}
-
 	if cfg.CreateLicenses && digests.license != "" {
-		licenseResp, err = downloadOCIBlob(ctx, registry, repository, digests.license, token, "")
-		if err != nil {
-			closeResponses(binaryResp, sigResp)
-			return nil, nil, nil, err
+		if sidecars.license, err = readOCIBlob(ctx, oci, digests.license); err != nil {
+			return ociSidecars{}, err
 		}
 	}
-
-	return binaryResp, sigResp, licenseResp, nil
+	return sidecars, nil
 }
 
-type layerDigests struct {
-	binary, signature, license string
-}
-
-func findLayerDigests(layers []interface{}, title, titleNoExt string) layerDigests {
-	var digests layerDigests
-
-	for _, layer := range layers {
-		layerMap, ok := layer.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		annotations, ok := layerMap["annotations"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-		layerTitle, ok := annotations["org.opencontainers.image.title"].(string)
-		if !ok {
-			continue
-		}
+// readOCIBlob reads the whole blob at digest, which must be small, and checks it against its digest
+func readOCIBlob(ctx context.Context, oci *ociClient, digest string) ([]byte, error) {
+	resp, err := downloadOCIBlob(ctx, oci, digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-		digest := layerMap["digest"].(string)
-		switch layerTitle {
-		case title, titleNoExt:
-			digests.binary = digest
-		case title+".sig", titleNoExt+".sig":
-			digests.signature = digest
-		case "LICENSE", titleNoExt+".LICENSE":
-			digests.license = digest
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errOCILayerDownload.Wrap(transientRequestError(ctx, err))
+	}
+	if want, ok := strings.CutPrefix(digest, "sha256:"); ok {
+		if sum := sha256.Sum256(data); !strings.EqualFold(hex.EncodeToString(sum[:]), want) {
+			return nil, errChecksumMismatch.New("blob expected %s, got sha256:%x", digest, sum)
 		}
 	}
-
-	return digests
+	return data, nil
 }
 
-func downloadOCIBlob(ctx context.Context, registry, repository, digest, token, tmpPath string) (*http.Response, error) {
-	url := fmt.Sprintf("https://%s/v2/%s/blobs/%s", registry, repository, digest)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+// downloadOCIBlob requests the blob at digest, continuing the download that meta describes if it is not nil.
+// Blobs are addressed by their content, so they can be resumed without checking that they changed
+func downloadOCIBlob(ctx context.Context, oci *ociClient, digest string, meta *resumeMeta) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", oci.url("blobs", digest), nil)
 	if err != nil {
 		return nil, errOCILayerDownload.Wrap(err)
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
+	setRangeHeaders(req, meta, false)
 
-	if tmpPath != "" {
-		if meta, err := getOCIMeta(tmpPath); err == nil && meta.Offset > 0 {
-			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", meta.Offset))
+	resp, err := oci.do(req)
+	if err != nil {
+		return nil, errOCILayerDownload.Wrap(err)
+	}
+	if meta == nil {
+		if err := checkStatus(resp, http.StatusOK); err != nil {
+			resp.Body.Close()
+			return nil, errOCILayerDownload.Wrap(err)
 		}
+		return resp, nil
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && meta.Offset > 0 {
+		resp.Body.Close()
+		startOver(nil, meta)
+		return downloadOCIBlob(ctx, oci, digest, meta)
+	}
+	if err := checkStatus(resp, http.StatusOK, http.StatusPartialContent); err != nil {
+		resp.Body.Close()
 		return nil, errOCILayerDownload.Wrap(err)
 	}
 
+	if resp.StatusCode == http.StatusPartialContent {
+		if err := checkResumed(resp, meta); err != nil {
+			resp.Body.Close()
+			if meta.Offset == 0 {
+				return nil, errOCILayerDownload.Wrap(err)
+			}
+			startOver(nil, meta)
+			return downloadOCIBlob(ctx, oci, digest, meta)
+		}
+		return resp, nil
+	}
+
+	startOver(resp, meta)
 	return resp, nil
 }
 
@@ -795,22 +855,31 @@ func removeOldTempFile(filePath string, threshold time.Duration, now time.Time)
 	return nil
 }
 
-
-func httpCheckSignatureAndLicense(ctx context.Context, client *http.Client, url string) (hasSignature, hasLicense bool, err error) {
+// httpCheckSignatureAndLicense probes for the .sig and .LICENSE sidecars of url. A failed probe
+// only counts as "no signature" if the signature policy doesn't require one
+func httpCheckSignatureAndLicense(ctx context.Context, client *http.Client, url, signaturePolicy string) (hasSignature, hasLicense bool, err error) {
 	// Check for signature file (.sig)
-	sigReq, err := createHTTPRequest(ctx, "HEAD", url+".sig")
-	if err != nil {
-		// Only return error if we can't create the request
-		return false, false, errDownloadFailed.Wrap(err)
-	}
+	if signaturePolicy != signatureNone {
+		sigReq, err := createHTTPRequest(ctx, "HEAD", url+".sig")
+		if err != nil {
+			// Only return error if we can't create the request
+			return false, false, errDownloadFailed.Wrap(err)
+		}
 
-	sigResp, err := client.Do(sigReq)
-	if err != nil {
-		// HTTP request failed - treat as no signature file available
-		hasSignature = false
-	} else {
-		sigResp.Body.Close()
-		hasSignature = sigResp.StatusCode == http.StatusOK
+		sigResp, err := client.Do(sigReq)
+		if err != nil {
+			if signaturePolicy == signatureRequired {
+				return false, false, errSignatureMissing.Wrap(err)
+			}
+			// HTTP request failed - treat as no signature file available
+			hasSignature = false
+		} else {
+			sigResp.Body.Close()
+			hasSignature = sigResp.StatusCode == http.StatusOK
+			if !hasSignature && signaturePolicy == signatureRequired {
+				return false, false, errSignatureMissing.New("%s.sig: status code %d", url, sigResp.StatusCode)
+			}
+		}
 	}
 
 	// Check for license file (.LICENSE)
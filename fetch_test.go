@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckVerifiable(t *testing.T) {
+	pinned := "oci://ghcr.io/org/app@sha256:" + strings.Repeat("a", 64)
+
+	tests := []struct {
+		name    string
+		bEntry  binaryEntry
+		wantErr bool
+	}{
+		{"http with b3sum", binaryEntry{DownloadURL: "https://example.com/app", Bsum: strings.Repeat("b", 64)}, false},
+		{"http with sha256", binaryEntry{DownloadURL: "https://example.com/app", Shasum: strings.Repeat("c", 64)}, false},
+		{"http without checksum", binaryEntry{DownloadURL: "https://example.com/app"}, true},
+		{"http marked no_check", binaryEntry{DownloadURL: "https://example.com/app", Bsum: "!no_check"}, true},
+		{"oci pinned to a manifest digest", binaryEntry{DownloadURL: pinned}, false},
+		{"oci tag and digest", binaryEntry{DownloadURL: "oci://ghcr.io/org/app:v1@sha256:" + strings.Repeat("a", 64)}, false},
+		{"oci tag", binaryEntry{DownloadURL: "oci://ghcr.io/org/app:latest"}, true},
+		{"oci tag with b3sum", binaryEntry{DownloadURL: "oci://ghcr.io/org/app:latest", Bsum: strings.Repeat("b", 64)}, false},
+		{"oci invalid digest", binaryEntry{DownloadURL: "oci://ghcr.io/org/app@sha256:abc"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkVerifiable(&tt.bEntry, &config{ChecksumPolicy: policyStrict})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkVerifiable(%s) = %v, want error: %v", tt.bEntry.DownloadURL, err, tt.wantErr)
+			}
+			if err != nil && !errUnverified.Has(err) {
+				t.Errorf("checkVerifiable(%s) = %v, want errUnverified", tt.bEntry.DownloadURL, err)
+			}
+		})
+	}
+}
+
+func TestCreateHTTPRequestBypassesCaches(t *testing.T) {
+	req, err := createHTTPRequest(context.Background(), "GET", "https://example.com/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{"Cache-Control": "no-cache, no-store, must-revalidate", "Pragma": "no-cache", "Expires": "0"} {
+		if got := req.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	for _, header := range []string{"If-None-Match", "If-Modified-Since"} {
+		if got := req.Header.Get(header); got != "" {
+			t.Errorf("%s = %q, only index requests are conditional", header, got)
+		}
+	}
+}
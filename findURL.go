@@ -8,10 +8,24 @@ import (
 	"github.com/zeebo/errs"
 )
 
-func findMatchingBins(bEntry binaryEntry, uRepoIndex []binaryEntry) []binaryEntry {
+// findMatchingBins returns the entries that match bEntry and that this machine can run, the one to pick first
+func findMatchingBins(bEntry binaryEntry, uRepoIndex *repoIndex) []binaryEntry {
+	matchingBins, _ := splitBySupport(matchBins(bEntry, uRepoIndex))
+	sortCandidates(matchingBins, uRepoIndex.preferences)
+	return matchingBins
+}
+
+// matchBins returns every entry that matches bEntry, in the order of the index
+func matchBins(bEntry binaryEntry, uRepoIndex *repoIndex) []binaryEntry {
 	var matchingBins []binaryEntry
 
-	for _, bin := range uRepoIndex {
+	// Only the entries with the requested name, or pkg_id when there is one, can match
+	candidates := uRepoIndex.named(bEntry.Name)
+	if bEntry.PkgID != "" {
+		candidates = uRepoIndex.withPkgID(bEntry.PkgID)
+	}
+
+	for _, bin := range candidates {
 		// Match based on the format hierarchy: name#id:version@repo, name#id@repo, name#id:version, name#id, name@repo, name
 		matches := false
 		if bin.Name == bEntry.Name {
@@ -63,13 +77,7 @@ func hasMatchingSnapshot(bin *binaryEntry, version string) bool {
 	// First, check all snapshot commits
 	for _, snap := range bin.Snapshots {
 		if version == snap.Commit {
-			// Modify the URL to use the snapshot's commit for OCI URLs
-			idx := strings.LastIndex(bin.DownloadURL, ":")
-			if idx != -1 {
-				bin.DownloadURL = bin.DownloadURL[:idx+1] + snap.Commit
-				bin.Bsum = "!no_check"
-				bin.Version = snap.Version
-			}
+			useSnapshot(bin, snap)
 			return true
 		}
 	}
@@ -77,20 +85,22 @@ func hasMatchingSnapshot(bin *binaryEntry, version string) bool {
 	// Then, check all snapshot versions
 	for _, snap := range bin.Snapshots {
 		if version == snap.Version {
-			// Modify the URL to use the snapshot's commit for OCI URLs
-			idx := strings.LastIndex(bin.DownloadURL, ":")
-			if idx != -1 {
-				bin.DownloadURL = bin.DownloadURL[:idx+1] + snap.Commit
-				bin.Bsum = "!no_check"
-				bin.Version = snap.Version
-			}
+			useSnapshot(bin, snap)
 			return true
 		}
 	}
 	return false
 }
 
-func findURL(bEntries []binaryEntry, uRepoIndex []binaryEntry, config *config) ([]binaryEntry, error) {
+// useSnapshot points the OCI URL of bin to the snapshot's commit (a tag, or a manifest digest). The
+// digests of the index are those of the latest build, so the snapshot is verified against its manifest instead
+func useSnapshot(bin *binaryEntry, snap snapshot) {
+	bin.DownloadURL = ociReferenceAt(bin.DownloadURL, snap.Commit)
+	bin.Bsum, bin.Shasum = "", ""
+	bin.Version = snap.Version
+}
+
+func findURL(bEntries []binaryEntry, uRepoIndex *repoIndex, config *config) ([]binaryEntry, error) {
 	// Check for duplicate names in bEntries
 	nameCount := make(map[string]int)
 	for _, bEntry := range bEntries {
@@ -114,17 +124,7 @@ func findURL(bEntries []binaryEntry, uRepoIndex []binaryEntry, config *config) (
 			continue
 		} else {
 			// Check if the binary is installed and update bEntry with installed metadata if available
-					if instBEntry := bEntryOfinstalledBinary(filepath.Join(config.InstallDir, bEntry.Name)); instBEntry.Name != "" {
-						if bEntry.PkgID == "" {
-							bEntry.PkgID = instBEntry.PkgID
-						}
-						if bEntry.Version == "" {
-							bEntry.Version = instBEntry.Version
-						}
-						if bEntry.Repository.Name == "" {
-							bEntry.Repository.Name = instBEntry.Repository.Name
-						}
-					}
+					bEntry, _ = withInstalledMetadata(config, bEntry)
 
 					matchingBins := findMatchingBins(bEntry, uRepoIndex)
 
@@ -134,7 +134,7 @@ func findURL(bEntries []binaryEntry, uRepoIndex []binaryEntry, config *config) (
 							DownloadURL: "!not_found",
 							Bsum:        "!no_check",
 						})
-						allErrors = append(allErrors, fmt.Errorf("didn't find download URL for [%s]", parseBinaryEntry(bEntry, false)))
+						allErrors = append(allErrors, fmt.Errorf("didn't find download URL for [%s]%s%s", parseBinaryEntry(bEntry, false), providedBy(bEntry.Name, uRepoIndex), unsupportedMatches(bEntry, uRepoIndex)))
 						continue
 					}
 
@@ -181,3 +181,47 @@ func findURL(bEntries []binaryEntry, uRepoIndex []binaryEntry, config *config) (
 
 	return results, nil
 }
+
+// providedBy hints at the packages that provide name, for when no binary is called like that
+func providedBy(name string, uRepoIndex *repoIndex) string {
+	var providers []string
+	for _, bin := range uRepoIndex.providing(name) {
+		providers = append(providers, parseBinaryEntry(bin, false))
+	}
+	if len(providers) == 0 {
+		return ""
+	}
+	return ", it is provided by " + strings.Join(providers, ", ")
+}
+
+// unsupportedMatches hints at the variants that match bEntry but were left out, because this machine can't run them
+func unsupportedMatches(bEntry binaryEntry, uRepoIndex *repoIndex) string {
+	_, unsupported := splitBySupport(matchBins(bEntry, uRepoIndex))
+	var variants []string
+	for _, bin := range unsupported {
+		variants = append(variants, parseBinaryEntry(bin, false)+" is "+unsupportedVariant(bin.PkgID))
+	}
+	if len(variants) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(variants, "; ") + ")"
+}
+
+// withInstalledMetadata fills in what bEntry leaves unspecified with the metadata of the installed binary, if
+// it is installed, so that it keeps being taken from the same package
+func withInstalledMetadata(config *config, bEntry binaryEntry) (binaryEntry, bool) {
+	instBEntry := bEntryOfinstalledBinary(filepath.Join(config.InstallDir, bEntry.Name))
+	if instBEntry.Name == "" {
+		return bEntry, false
+	}
+	if bEntry.PkgID == "" {
+		bEntry.PkgID = instBEntry.PkgID
+	}
+	if bEntry.Version == "" {
+		bEntry.Version = instBEntry.Version
+	}
+	if bEntry.Repository.Name == "" {
+		bEntry.Repository.Name = instBEntry.Repository.Name
+	}
+	return bEntry, true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// benchmarkEntries builds an index about the size of the ones dbin is used with: thousands of packages,
+// most of them built in several variants, spread over two repositories
+func benchmarkEntries() []binaryEntry {
+	repos := []repoRef{{Name: "bincache", repo: &repository{}}, {Name: "pkgcache", repo: &repository{}}}
+	variants := []string{"musl", "glibc", "musl-v3", "glibc-v3"}
+
+	var entries []binaryEntry
+	for i := range 6000 {
+		name := fmt.Sprintf("tool%d", i)
+		for j, variant := range variants[:1+i%len(variants)] {
+			entries = append(entries, binaryEntry{
+				Name:        name,
+				PkgID:       fmt.Sprintf("github.com.org%d.%s#%s", i%500, name, variant),
+				Version:     "1.0.0",
+				Description: "A tool that does something useful with files, processes and the network",
+				DownloadURL: fmt.Sprintf("https://example.com/%s/%s", variant, name),
+				ExtraBins:   fmt.Sprintf("%s-helper, %s-daemon", name, name),
+				Rank:        uint16(i + 1),
+				Repository:  repos[(i+j)%len(repos)],
+			})
+		}
+	}
+	return entries
+}
+
+// scanMatchingBins is what findMatchingBins did before the index had lookup maps: go through every entry
+func scanMatchingBins(bEntry binaryEntry, entries []binaryEntry, prefs preferences) []binaryEntry {
+	var matchingBins []binaryEntry
+	for _, bin := range entries {
+		if bin.Name == bEntry.Name && (bEntry.PkgID == "" || bin.PkgID == bEntry.PkgID) {
+			matchingBins = append(matchingBins, bin)
+		}
+	}
+	matchingBins, _ = splitBySupport(matchingBins)
+	sortCandidates(matchingBins, prefs)
+	return matchingBins
+}
+
+func BenchmarkFindMatchingBins(b *testing.B) {
+	entries := benchmarkEntries()
+	prefs := preferences{Prefer: []string{"*glibc*"}}
+	idx := newRepoIndex(entries, prefs)
+
+	queries := []binaryEntry{
+		{Name: "tool0"},
+		{Name: "tool2999"},
+		{Name: "tool5999", PkgID: "github.com.org499.tool5999#glibc-v3"},
+		{Name: "missing"},
+	}
+	for _, query := range queries {
+		pkgIDs := func(bins []binaryEntry) []string {
+			var ids []string
+			for _, bin := range bins {
+				ids = append(ids, bin.PkgID)
+			}
+			return ids
+		}
+		if got, want := pkgIDs(findMatchingBins(query, idx)), pkgIDs(scanMatchingBins(query, entries, prefs)); !slices.Equal(got, want) {
+			b.Fatalf("%s: the lookup maps found %q, a scan finds %q", query.Name, got, want)
+		}
+	}
+	b.Logf("%d entries", len(entries))
+
+	b.Run("maps", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			findMatchingBins(queries[i%len(queries)], idx)
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			scanMatchingBins(queries[i%len(queries)], entries, prefs)
+		}
+	})
+}
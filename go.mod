@@ -15,6 +15,7 @@ require (
 	github.com/jedisct1/go-minisign v0.0.0-20241212093149-d2f9f49435c7
 	github.com/k3a/html2text v1.2.1
 	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/cpuid/v2 v2.3.0
 	github.com/pkg/xattr v0.4.12
 	github.com/shamaton/msgpack/v2 v2.2.3
 	github.com/tdewolff/minify/v2 v2.23.10
@@ -22,17 +23,17 @@ require (
 	github.com/urfave/cli/v3 v3.3.8
 	github.com/zeebo/blake3 v0.2.4
 	github.com/zeebo/errs v1.4.0
+	golang.org/x/net v0.42.0
 	golang.org/x/term v0.33.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/klauspost/cpuid/v2 v2.3.0 // indirect
 	github.com/tdewolff/parse/v2 v2.8.1 // indirect
 	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/crypto v0.40.0 // indirect
-	golang.org/x/net v0.42.0 // indirect
 	golang.org/x/sys v0.34.0 // indirect
+	golang.org/x/text v0.27.0 // indirect
 )
 
 replace github.com/xplshn/pkggodev => /Users/anto/Documents/TrulyMine/pkggodev
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/cpuid/v2"
+)
+
+var (
+	// The index generator marks variants built for an x86-64 microarchitecture level like "musl-v3", "glibc-v4"
+	x64LevelMarker = regexp.MustCompile(`-v([234])(?:$|\.)`)
+	// and variants built for optional ARM features like "musl-v8.2", "glibc-sve"
+	armFeatureMarker = regexp.MustCompile(`-(v8\.1|v8\.2|sve)(?:$|\.)`)
+	armFeatures      = map[string][]cpuid.FeatureID{
+		"v8.1": {cpuid.ATOMICS},
+		"v8.2": {cpuid.ATOMICS, cpuid.DCPOP},
+		"sve":  {cpuid.SVE},
+	}
+)
+
+// cpu is what variants are checked against
+type cpu struct {
+	arch     string
+	x64Level int
+	supports func(ids ...cpuid.FeatureID) bool
+}
+
+var hostCPU = cpu{arch: runtime.GOARCH, x64Level: cpuid.CPU.X64Level(), supports: cpuid.CPU.Supports}
+
+// unsupportedVariant returns why this machine can't run the variant of a package whose pkg_id is pkgID,
+// or "" if it can (or if pkgID names no variant that it knows of)
+func unsupportedVariant(pkgID string) string {
+	return hostCPU.unsupported(pkgID)
+}
+
+func (c cpu) unsupported(pkgID string) string {
+	switch c.arch {
+	case "amd64":
+		if m := x64LevelMarker.FindStringSubmatch(pkgID); m != nil {
+			level, _ := strconv.Atoi(m[1])
+			if c.x64Level < level {
+				return fmt.Sprintf("built for x86-64-v%d, but this CPU is x86-64-v%d", level, c.x64Level)
+			}
+		}
+	case "arm64":
+		if m := armFeatureMarker.FindStringSubmatch(pkgID); m != nil {
+			var missing []string
+			for _, feature := range armFeatures[m[1]] {
+				if !c.supports(feature) {
+					missing = append(missing, feature.String())
+				}
+			}
+			if len(missing) > 0 {
+				return fmt.Sprintf("built for %s, but this CPU lacks %s", m[1], strings.Join(missing, ", "))
+			}
+		}
+	}
+	return ""
+}
+
+// splitBySupport separates the entries that this machine can run from those it can't
+func splitBySupport(bEntries []binaryEntry) (supported, unsupported []binaryEntry) {
+	for _, bEntry := range bEntries {
+		if unsupportedVariant(bEntry.PkgID) == "" {
+			supported = append(supported, bEntry)
+		} else {
+			unsupported = append(unsupported, bEntry)
+		}
+	}
+	return supported, unsupported
+}
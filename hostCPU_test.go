@@ -0,0 +1,84 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/klauspost/cpuid/v2"
+)
+
+func armCPU(features ...cpuid.FeatureID) cpu {
+	return cpu{arch: "arm64", supports: func(ids ...cpuid.FeatureID) bool {
+		for _, id := range ids {
+			if !slices.Contains(features, id) {
+				return false
+			}
+		}
+		return true
+	}}
+}
+
+func TestUnsupportedVariants(t *testing.T) {
+	x64v2 := cpu{arch: "amd64", x64Level: 2}
+	x64v3 := cpu{arch: "amd64", x64Level: 3}
+	armv8 := armCPU()
+	armv81 := armCPU(cpuid.ATOMICS)
+	armSVE := armCPU(cpuid.ATOMICS, cpuid.DCPOP, cpuid.SVE)
+
+	tests := []struct {
+		name  string
+		cpu   cpu
+		pkgID string
+		runs  bool
+	}{
+		{"baseline on x86-64-v2", x64v2, "github.com.sharkdp.bat#musl", true},
+		{"v2 on x86-64-v2", x64v2, "github.com.sharkdp.bat#musl-v2", true},
+		{"v3 on x86-64-v2", x64v2, "github.com.sharkdp.bat#musl-v3", false},
+		{"v3 upx on x86-64-v2", x64v2, "github.com.sharkdp.bat#glibc-v3.upx", false},
+		{"v3 on x86-64-v3", x64v3, "github.com.sharkdp.bat#glibc-v3", true},
+		{"v4 on x86-64-v3", x64v3, "github.com.sharkdp.bat#glibc-v4", false},
+		{"unknown level", x64v2, "github.com.sharkdp.bat#musl-v5", true},
+		{"not a marker", x64v2, "github.com.sharkdp.bat#musl-v30", true},
+		{"ARM marker on x86-64", x64v2, "github.com.sharkdp.bat#musl-sve", true},
+		{"baseline on ARMv8.0", armv8, "github.com.sharkdp.bat#musl", true},
+		{"v8.1 on ARMv8.0", armv8, "github.com.sharkdp.bat#musl-v8.1", false},
+		{"v8.1 with LSE", armv81, "github.com.sharkdp.bat#musl-v8.1", true},
+		{"v8.2 with LSE only", armv81, "github.com.sharkdp.bat#glibc-v8.2.upx", false},
+		{"sve without SVE", armv81, "github.com.sharkdp.bat#musl-sve", false},
+		{"sve with SVE", armSVE, "github.com.sharkdp.bat#musl-sve", true},
+		{"v8.2 with SVE", armSVE, "github.com.sharkdp.bat#glibc-v8.2", true},
+		{"x86-64 level on ARM", armv8, "github.com.sharkdp.bat#musl-v3", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reason := tt.cpu.unsupported(tt.pkgID); (reason == "") != tt.runs {
+				t.Errorf("unsupported(%q) = %q, want it to run: %v", tt.pkgID, reason, tt.runs)
+			}
+		})
+	}
+}
+
+func TestSplitBySupport(t *testing.T) {
+	defer func(host cpu) { hostCPU = host }(hostCPU)
+	hostCPU = armCPU(cpuid.ATOMICS)
+
+	var bEntries []binaryEntry
+	for _, pkgID := range []string{"bat#musl", "bat#musl-v8.1", "bat#musl-sve", "bat#glibc-v8.2", "bat#glibc-v3"} {
+		bEntries = append(bEntries, binaryEntry{Name: "bat", PkgID: pkgID})
+	}
+	supported, unsupported := splitBySupport(bEntries)
+
+	pkgIDs := func(bins []binaryEntry) []string {
+		var ids []string
+		for _, bin := range bins {
+			ids = append(ids, bin.PkgID)
+		}
+		return ids
+	}
+	if got, want := pkgIDs(supported), []string{"bat#musl", "bat#musl-v8.1", "bat#glibc-v3"}; !slices.Equal(got, want) {
+		t.Errorf("supported = %q, want %q", got, want)
+	}
+	if got, want := pkgIDs(unsupported), []string{"bat#musl-sve", "bat#glibc-v8.2"}; !slices.Equal(got, want) {
+		t.Errorf("unsupported = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errIndexCache = errs.Class("index cache error")
+)
+
+// The index cache holds the decoded index of every configured repository, merged, so that they don't have to
+// be decompressed, verified and decoded again on every run. It is laid out as follows:
+//
+//	magic (8 bytes) | key (32 bytes) | expires (int64, unix nanoseconds) | size of the table (uint32)
+//	table: repository count, then the position in config.Repositories and the name of each repository,
+//	       entry count, then the offset of each entry from the first one (uint32), so they can be read one by one,
+//	       and the keys of each entry: the ID of its repository, its name, pkg_id and the binaries it provides
+//	entries: the ID of their repository (its position in the table), then their fields
+//
+// Counts, IDs and lengths are uvarints, strings are prefixed with their length, fixed-size integers are little-endian
+const (
+	indexCacheMagic      = "DBINIDX\x02"
+	indexCacheFile       = ".index_cache.bin"
+	indexCacheKeySize    = 32 // the default size of a BLAKE3 hash
+	indexCacheHeaderSize = len(indexCacheMagic) + indexCacheKeySize + 8 + 4
+)
+
+// indexCache is a loaded index cache, its entries are only decoded when asked for
+type indexCache struct {
+	records []byte // the encoded entries
+	repos   []repoRef
+	offsets []uint32
+	keys    []cachedKey
+}
+
+// cachedKey is what an entry is looked up by, it is read with the table so that the entry itself doesn't have to be
+type cachedKey struct {
+	repo                   uint64
+	name, pkgID, extraBins string
+}
+
+// cacheExpiry keeps the earliest time at which one of the files an index was loaded from has to be refreshed
+type cacheExpiry struct {
+	mu sync.Mutex
+	at time.Time
+}
+
+func (e *cacheExpiry) observe(t time.Time) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.at.IsZero() || t.Before(e.at) {
+		e.at = t
+	}
+}
+
+// entryStrings and entryLists point to the fields of e, in the order they are stored in
+func entryStrings(e *binaryEntry) []*string {
+	return []*string{
+		&e.Name, &e.PrettyName, &e.PkgID, &e.Description, &e.Version, &e.DownloadURL, &e.Icon, &e.Size, &e.Bsum, &e.Shasum,
+		&e.BuildDate, &e.BuildScript, &e.BuildLog, &e.Categories, &e.ExtraBins, &e.Maintainers, &e.WebManifest,
+	}
+}
+
+func entryLists(e *binaryEntry) []*[]string {
+	return []*[]string{&e.Screenshots, &e.License, &e.Notes, &e.SrcURLs, &e.WebURLs}
+}
+
+func indexCachePath(config *config) string {
+	return filepath.Join(config.CacheDir, indexCacheFile)
+}
+
+// indexSources lists the files that the index of repo is loaded from
+func indexSources(config *config, repo repository) []string {
+	var sources []string
+	if path, ok := strings.CutPrefix(repo.URL, "file://"); ok {
+		sources = append(sources, path, strings.TrimPrefix(timestampURL(repo.URL), "file://"))
+	} else {
+		sources = append(sources, cachePath(config, repo.URL, ""), deltaBasePath(config, repo), cachePath(config, timestampURL(repo.URL), ""))
+	}
+	if path, ok := strings.CutPrefix(revocationURL(repo), "file://"); ok {
+		sources = append(sources, path)
+	} else {
+		sources = append(sources, cachePath(config, revocationURL(repo), ""))
+	}
+	return sources
+}
+
+// indexCacheKey changes whenever the configured repositories, or the files their indexes are loaded from, do
+func indexCacheKey(config *config) []byte {
+	hash := blake3.New()
+	fmt.Fprintf(hash, "%s %v %s %s\n", indexCacheMagic, version, config.CacheDir, config.TrustStore)
+	repos, _ := json.Marshal(config.Repositories)
+	hash.Write(repos)
+
+	for _, path := range append([]string{config.TrustStore}, func() (sources []string) {
+		for _, repo := range config.Repositories {
+			sources = append(sources, indexSources(config, repo)...)
+		}
+		return sources
+	}()...) {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(hash, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(hash, "%s -\n", path)
+		}
+	}
+	return hash.Sum(nil)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// saveIndexCache writes entries to the index cache, configIndex tells the position in config.Repositories
+// of the repository of each entry. The cache is valid until expires
+func saveIndexCache(config *config, entries []binaryEntry, configIndex map[*repository]int, expires time.Time) error {
+	repoIDs := make(map[repoRef]uint64)
+	var table, records []byte
+	var repoTable, keys []byte
+	offsets := make([]byte, 0, 4*len(entries))
+
+	for i := range entries {
+		e := &entries[i]
+		ref := repoRef{Name: e.Repository.Name, repo: e.Repository.repo}
+		id, ok := repoIDs[ref]
+		if !ok {
+			index, configured := configIndex[ref.repo]
+			if !configured {
+				return errIndexCache.New("%s does not come from a configured repository", parseBinaryEntry(*e, false))
+			}
+			id = uint64(len(repoIDs))
+			repoIDs[ref] = id
+			repoTable = binary.AppendUvarint(repoTable, uint64(index))
+			repoTable = appendString(repoTable, ref.Name)
+		}
+
+		if len(records) > math.MaxUint32 {
+			return errIndexCache.New("too many entries")
+		}
+		offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(records)))
+		keys = binary.AppendUvarint(keys, id)
+		keys = appendString(appendString(appendString(keys, e.Name), e.PkgID), e.ExtraBins)
+
+		records = binary.AppendUvarint(records, id)
+		for _, field := range entryStrings(e) {
+			records = appendString(records, *field)
+		}
+		for _, list := range entryLists(e) {
+			records = binary.AppendUvarint(records, uint64(len(*list)))
+			for _, s := range *list {
+				records = appendString(records, s)
+			}
+		}
+		records = binary.AppendUvarint(records, uint64(len(e.Snapshots)))
+		for _, snap := range e.Snapshots {
+			records = appendString(appendString(records, snap.Commit), snap.Version)
+		}
+		records = binary.AppendUvarint(records, uint64(e.Rank))
+	}
+
+	table = binary.AppendUvarint(table, uint64(len(repoIDs)))
+	table = append(table, repoTable...)
+	table = binary.AppendUvarint(table, uint64(len(entries)))
+	table = append(table, offsets...)
+	table = append(table, keys...)
+
+	header := make([]byte, 0, indexCacheHeaderSize)
+	header = append(header, indexCacheMagic...)
+	header = append(header, indexCacheKey(config)...)
+	header = binary.LittleEndian.AppendUint64(header, uint64(expires.UnixNano()))
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(table)))
+
+	path := indexCachePath(config)
+	if err := os.MkdirAll(config.CacheDir, 0755); err != nil {
+		return errCacheAccess.Wrap(err)
+	}
+	tempFile := path + ".tmp"
+	if err := os.WriteFile(tempFile, append(append(header, table...), records...), 0644); err != nil {
+		return errCacheAccess.Wrap(err)
+	}
+	return errCacheAccess.Wrap(os.Rename(tempFile, path))
+}
+
+// indexReader decodes what saveIndexCache encoded, remembering the first error
+type indexReader struct {
+	buf []byte
+	err error
+}
+
+func (r *indexReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errIndexCache.New("truncated or corrupt")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *indexReader) string() string {
+	n := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if uint64(len(r.buf)) < n {
+		r.err = errIndexCache.New("truncated or corrupt")
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+// openIndexCache reads the index cache, if it is still valid for config. Only its table is decoded
+func openIndexCache(config *config) (*indexCache, error) {
+	file, err := os.Open(indexCachePath(config))
+	if err != nil {
+		return nil, errIndexCache.Wrap(err)
+	}
+	defer file.Close()
+
+	cache, err := readIndexCacheTable(config, file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(file, cache.records); err != nil {
+		return nil, errIndexCache.Wrap(err)
+	}
+	return cache, nil
+}
+
+func readIndexCacheTable(config *config, file *os.File) (*indexCache, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errIndexCache.Wrap(err)
+	}
+	header := make([]byte, indexCacheHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil || string(header[:len(indexCacheMagic)]) != indexCacheMagic {
+		return nil, errIndexCache.New("not an index cache")
+	}
+	key := header[len(indexCacheMagic) : len(indexCacheMagic)+indexCacheKeySize]
+	if string(key) != string(indexCacheKey(config)) {
+		return nil, errIndexCache.New("the repositories or their indexes changed")
+	}
+	expires := time.Unix(0, int64(binary.LittleEndian.Uint64(header[len(indexCacheMagic)+indexCacheKeySize:])))
+	if time.Now().After(expires) {
+		return nil, errIndexCache.New("the indexes have to be refreshed since %s", expires.Format(time.RFC3339))
+	}
+
+	tableSize := int64(binary.LittleEndian.Uint32(header[indexCacheHeaderSize-4:]))
+	if int64(indexCacheHeaderSize)+tableSize > info.Size() {
+		return nil, errIndexCache.New("truncated or corrupt")
+	}
+	table := make([]byte, tableSize)
+	if _, err := io.ReadFull(file, table); err != nil {
+		return nil, errIndexCache.Wrap(err)
+	}
+
+	// The entries of a repository share it, with its revocation list
+	repos := make(map[uint64]*repository)
+	r := &indexReader{buf: table}
+	cache := &indexCache{records: make([]byte, info.Size()-int64(indexCacheHeaderSize)-tableSize)}
+
+	for i, n := uint64(0), r.uvarint(); i < n && r.err == nil; i++ {
+		index, name := r.uvarint(), r.string()
+		if r.err != nil || index >= uint64(len(config.Repositories)) {
+			return nil, errIndexCache.New("truncated or corrupt")
+		}
+		repo, ok := repos[index]
+		if !ok {
+			// The revocation lists are part of the key of the cache, the copies it was built with are still good
+			configured := config.Repositories[index]
+			cached := configured
+			cached.cachedOnly = true
+			if configured.revocations, err = fetchRevocationList(config, cached); err != nil {
+				return nil, err
+			}
+			repo = &configured
+			repos[index] = repo
+		}
+		cache.repos = append(cache.repos, repoRef{Name: name, repo: repo})
+	}
+
+	count := r.uvarint()
+	if r.err != nil || uint64(len(r.buf)) < 4*count {
+		return nil, errIndexCache.New("truncated or corrupt")
+	}
+	cache.offsets = make([]uint32, count)
+	for i := range cache.offsets {
+		cache.offsets[i] = binary.LittleEndian.Uint32(r.buf[4*i:])
+		if int(cache.offsets[i]) > len(cache.records) || (i > 0 && cache.offsets[i] < cache.offsets[i-1]) {
+			return nil, errIndexCache.New("truncated or corrupt")
+		}
+	}
+	r.buf = r.buf[4*count:]
+
+	cache.keys = make([]cachedKey, count)
+	for i := range cache.keys {
+		cache.keys[i] = cachedKey{repo: r.uvarint(), name: r.string(), pkgID: r.string(), extraBins: r.string()}
+		if cache.keys[i].repo >= uint64(len(cache.repos)) && r.err == nil {
+			r.err = errIndexCache.New("entry refers to repository %d, out of %d", cache.keys[i].repo, len(cache.repos))
+		}
+	}
+	if r.err == nil && len(r.buf) != 0 {
+		r.err = errIndexCache.New("truncated or corrupt")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return cache, nil
+}
+
+func (c *indexCache) len() int {
+	return len(c.offsets)
+}
+
+// entry decodes the i-th entry of the cache
+func (c *indexCache) entry(i int) (binaryEntry, error) {
+	end := len(c.records)
+	if i+1 < len(c.offsets) {
+		end = int(c.offsets[i+1])
+	}
+	r := &indexReader{buf: c.records[c.offsets[i]:end]}
+	return c.decodeEntry(r), r.err
+}
+
+// entries decodes every entry of the cache at once
+func (c *indexCache) entries() ([]binaryEntry, error) {
+	r := &indexReader{buf: c.records}
+	entries := make([]binaryEntry, len(c.offsets))
+	for i := range entries {
+		entries[i] = c.decodeEntry(r)
+	}
+	if r.err == nil && len(r.buf) != 0 {
+		r.err = errIndexCache.New("truncated or corrupt")
+	}
+	return entries, r.err
+}
+
+func (c *indexCache) decodeEntry(r *indexReader) binaryEntry {
+	var e binaryEntry
+	if id := r.uvarint(); id < uint64(len(c.repos)) {
+		e.Repository = c.repos[id]
+	} else if r.err == nil {
+		r.err = errIndexCache.New("entry refers to repository %d, out of %d", id, len(c.repos))
+	}
+	for _, field := range entryStrings(&e) {
+		*field = r.string()
+	}
+	for _, list := range entryLists(&e) {
+		if n := r.uvarint(); n > 0 && n <= uint64(len(r.buf)) {
+			*list = make([]string, n)
+			for i := range *list {
+				(*list)[i] = r.string()
+			}
+		}
+	}
+	if n := r.uvarint(); n > 0 && n <= uint64(len(r.buf)) {
+		e.Snapshots = make([]snapshot, n)
+		for i := range e.Snapshots {
+			e.Snapshots[i] = snapshot{Commit: r.string(), Version: r.string()}
+		}
+	}
+	e.Rank = uint16(r.uvarint())
+	return e
+}
+
+// loadIndexCache returns the index cache, if it is still valid for config. Its entries are only decoded once they
+// are looked up
+func loadIndexCache(config *config) (*repoIndex, error) {
+	cache, err := openIndexCache(config)
+	if err != nil {
+		return nil, err
+	}
+	return newCachedRepoIndex(cache, config.Preferences), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIndexCacheDecodesOnLookup(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config{
+		CacheDir:     filepath.Join(dir, "cache"),
+		TrustStore:   filepath.Join(dir, "trusted_keys.yaml"),
+		Repositories: []repository{{URL: "file://" + filepath.Join(dir, "index.json")}},
+	}
+	repo := repoRef{Name: "bincache", repo: &cfg.Repositories[0]}
+	entries := []binaryEntry{
+		{Name: "bat", PkgID: "github.com.sharkdp.bat#musl", Version: "0.25.0", Repository: repo, Rank: 3},
+		{Name: "busybox", PkgID: "busybox#musl", ExtraBins: "ls, cat", Repository: repo, License: []string{"GPL-2.0"}},
+		{Name: "bat", PkgID: "github.com.sharkdp.bat#glibc", Repository: repo, Snapshots: []snapshot{{Commit: "abc", Version: "0.24.0"}}},
+	}
+	if err := saveIndexCache(cfg, entries, map[*repository]int{repo.repo: 0}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := loadIndexCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := slices.Index(idx.decoded, true); n != -1 {
+		t.Fatalf("entry %d was decoded before it was looked up", n)
+	}
+
+	// Entries are compared without the repository they point to, which is a copy
+	same := func(got, want []binaryEntry) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			g, w := got[i], want[i]
+			if g.Repository.Name != w.Repository.Name || g.Repository.config().URL != w.Repository.config().URL {
+				return false
+			}
+			g.Repository, w.Repository = repoRef{}, repoRef{}
+			if !reflect.DeepEqual(g, w) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if got := idx.named("bat"); !same(got, []binaryEntry{entries[0], entries[2]}) {
+		t.Errorf("named(bat) = %+v", got)
+	}
+	if !slices.Equal(idx.decoded, []bool{true, false, true}) {
+		t.Errorf("decoded %v, want only the entries of bat", idx.decoded)
+	}
+	if got := idx.providing("cat"); !same(got, entries[1:2]) {
+		t.Errorf("providing(cat) = %+v", got)
+	}
+	if !idx.contains("bat", "github.com.sharkdp.bat#glibc") || idx.contains("busybox", "github.com.sharkdp.bat#glibc") {
+		t.Error("contains does not match name and pkg_id together")
+	}
+	if got := idx.all(); !same(got, entries) {
+		t.Errorf("all() = %+v", got)
+	}
+	if idx.cache != nil {
+		t.Error("the cache is still kept once every entry was decoded")
+	}
+}
+
+func TestIndexCacheUsesCachedRevocations(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(`{"revoked":[{"pkg_id":"bat#musl","reason":"compromised"}]}`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	cfg := &config{
+		CacheDir:     filepath.Join(dir, "cache"),
+		TrustStore:   filepath.Join(dir, "trusted_keys.yaml"),
+		Repositories: []repository{{URL: "file://" + filepath.Join(dir, "index.json"), RevocationURL: srv.URL + "/revocations"}},
+	}
+	// As the index is loaded, its revocation list is fetched and cached
+	if _, err := fetchRevocationList(cfg, cfg.Repositories[0]); err != nil {
+		t.Fatal(err)
+	}
+	repo := repoRef{Name: "bincache", repo: &cfg.Repositories[0]}
+	entries := []binaryEntry{{Name: "bat", PkgID: "bat#musl", Repository: repo}}
+	if err := saveIndexCache(cfg, entries, map[*repository]int{repo.repo: 0}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := loadIndexCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("the revocation list was requested %d times, want only once, before the cache was built", n)
+	}
+	bat := idx.named("bat")
+	if len(bat) != 1 || checkRevoked(&bat[0]) == nil {
+		t.Errorf("%+v is not revoked by the cached list", bat)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -47,6 +48,7 @@ func infoCommand() *cli.Command {
 					return err
 				}
 				bEntry = stringToBinaryEntry(c.Args().First())
+				warnUnsupportedVariant(bEntryOfinstalledBinary(filepath.Join(config.InstallDir, bEntry.Name)))
 				binaryInfo, err := getBinaryInfo(config, bEntry, uRepoIndex)
 				if err != nil {
 					return errBinaryInfoNotFound.Wrap(err)
@@ -88,6 +90,7 @@ func infoCommand() *cli.Command {
 				}
 				for _, program := range binaryEntries {
 					fmt.Println(parseBinaryEntry(program, true))
+					warnUnsupportedVariant(program)
 				}
 			}
 			return nil
@@ -95,7 +98,14 @@ func infoCommand() *cli.Command {
 	}
 }
 
-func findBinaryInfo(bEntry binaryEntry, uRepoIndex []binaryEntry) (binaryEntry, bool) {
+// warnUnsupportedVariant warns if the installed binary bEntry is a variant that this machine can't run
+func warnUnsupportedVariant(bEntry binaryEntry) {
+	if reason := unsupportedVariant(bEntry.PkgID); reason != "" && verbosityLevel >= silentVerbosityWithErrors {
+		fmt.Fprintf(os.Stderr, "%swarning%s: %s is %s, it may not run on this machine\n", yellowColor, resetColor, parseBinaryEntry(bEntry, false), reason)
+	}
+}
+
+func findBinaryInfo(bEntry binaryEntry, uRepoIndex *repoIndex) (binaryEntry, bool) {
 	matchingBins := findMatchingBins(bEntry, uRepoIndex)
 
 	if len(matchingBins) == 0 {
@@ -105,13 +115,17 @@ func findBinaryInfo(bEntry binaryEntry, uRepoIndex []binaryEntry) (binaryEntry,
 	return matchingBins[0], true
 }
 
-func getBinaryInfo(config *config, bEntry binaryEntry, uRepoIndex []binaryEntry) (*binaryEntry, error) {
-	if instBEntry := bEntryOfinstalledBinary(filepath.Join(config.InstallDir, bEntry.Name)); bEntry.PkgID == "" && instBEntry.PkgID != "" {
+func getBinaryInfo(config *config, bEntry binaryEntry, uRepoIndex *repoIndex) (*binaryEntry, error) {
+	instBEntry := bEntryOfinstalledBinary(filepath.Join(config.InstallDir, bEntry.Name))
+	if bEntry.PkgID == "" && instBEntry.PkgID != "" {
 		bEntry = instBEntry
 	}
 
 	binInfo, found := findBinaryInfo(bEntry, uRepoIndex)
 	if found {
+		if instBEntry.PkgID == binInfo.PkgID {
+			binInfo.verifiedDigests = instBEntry.verifiedDigests
+		}
 		return &binInfo, nil
 	}
 
@@ -141,6 +155,7 @@ func printBEntry(bEntry *binaryEntry) {
 
 		{"B3SUM", bEntry.Bsum},
 		{"SHA256", bEntry.Shasum},
+		{"Verified", strings.Join(bEntry.verifiedDigests, ", ")},
 		{"Build Date", bEntry.BuildDate},
 		{"Build Script", bEntry.BuildScript},
 		{"Build Log", bEntry.BuildLog},
@@ -22,11 +22,20 @@ func installCommand() *cli.Command {
 		Name:    "install",
 		Aliases: []string{"add"},
 		Usage:   "Install binaries",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "arch",
+				Usage: "Platform to pick from multi-arch OCI images (e.g: arm64, linux/arm/v7)",
+			},
+		},
 		Action: func(_ context.Context, c *cli.Command) error {
 			config, err := loadConfig()
 			if err != nil {
 				return err
 			}
+			if c.IsSet("arch") {
+				config.Platform = c.String("arch")
+			}
 			uRepoIndex, err := fetchRepoIndex(config)
 			if err != nil {
 				return err
@@ -36,7 +45,7 @@ func installCommand() *cli.Command {
 	}
 }
 
-func installBinaries(ctx context.Context, config *config, bEntries []binaryEntry, uRepoIndex []binaryEntry) error {
+func installBinaries(ctx context.Context, config *config, bEntries []binaryEntry, uRepoIndex *repoIndex) error {
 	cursor.Hide()
 	defer cursor.Show()
 
@@ -85,9 +94,8 @@ func installBinaries(ctx context.Context, config *config, bEntries []binaryEntry
 
 	termWidth := getTerminalWidth()
 
-	for _, result := range filteredResults {
+	newDownloadScheduler(config).run(filteredResults, func(bEntry binaryEntry, done func()) {
 		wg.Add(1)
-		bEntry := result
 		destination := filepath.Join(config.InstallDir, filepath.Base(bEntry.Name))
 
 		if verbosityLevel >= normalVerbosity {
@@ -111,6 +119,7 @@ func installBinaries(ctx context.Context, config *config, bEntries []binaryEntry
 				progressbar.WithTaskAddBarOptions(pbarOpts...),
 				progressbar.WithTaskAddOnTaskProgressing(func(bar progressbar.PB, _ <-chan struct{}) (stop bool) {
 					defer wg.Done()
+					defer done()
 					err := fetchBinaryFromURLToDest(ctx, bar, &bEntry, destination, config)
 					if err != nil {
 						errorsMu.Lock()
@@ -147,6 +156,7 @@ func installBinaries(ctx context.Context, config *config, bEntries []binaryEntry
 		} else {
 			go func(bEntry binaryEntry, destination string) {
 				defer wg.Done()
+				defer done()
 				err := fetchBinaryFromURLToDest(ctx, nil, &bEntry, destination, config)
 				if err != nil {
 					errorsMu.Lock()
@@ -182,7 +192,7 @@ func installBinaries(ctx context.Context, config *config, bEntries []binaryEntry
 				}
 			}(bEntry, destination)
 		}
-	}
+	})
 
 	wg.Wait()
 
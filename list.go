@@ -54,9 +54,9 @@ func listCommand() *cli.Command {
 				return errListBinariesFailed.Wrap(err)
 			}
 			if c.Bool("detailed") {
-				return fSearch(config, []string{""}, uRepoIndex)
+				return fSearch(config, []string{""}, uRepoIndex.all())
 			}
-			bEntries, err := listBinaries(uRepoIndex)
+			bEntries, err := listBinaries(uRepoIndex.all())
 			if err != nil {
 				return errListBinariesFailed.Wrap(err)
 			}
@@ -66,9 +66,14 @@ func main() {
 			listCommand(),
 			searchCommand(),
 			infoCommand(),
+			resolveCommand(),
 			runCommand(),
 			updateCommand(),
 			configCommand(),
+			repoCommand(),
+			syncCommand(),
+			auditCommand(),
+			verifyCommand(),
 		},
 		EnableShellCompletion: true,
 	}
@@ -97,7 +102,7 @@ func main() {
 	}
 }
 
-func fetchRepoIndex(config *config) ([]binaryEntry, error) {
+func fetchRepoIndex(config *config) (*repoIndex, error) {
 	uRepoIndex, err := decodeRepoIndex(config)
 	if err != nil {
 		return nil, fmt.Errorf("%v: Consider checking if DBIN_NOCONFIG=1 works, if so, consider modifying your config, your repository URLs may be outdated.\nAlso consider removing dbin's cache if the above fails", err)
@@ -11,6 +11,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/goccy/go-json"
@@ -18,6 +19,7 @@ import (
 	minify "github.com/tdewolff/minify/v2"
 	mjson "github.com/tdewolff/minify/v2/json"
 	"github.com/tiendc/go-deepcopy"
+	"github.com/zeebo/blake3"
 )
 
 const (
@@ -26,6 +28,35 @@ const (
 	colorReset  = "\033[0m"
 )
 
+// Every index file written by a run shares the same version, dbin refuses to go back to an older one
+var (
+	indexVersion  = uint64(time.Now().Unix())
+	indexLifetime = 7 * 24 * time.Hour
+)
+
+type indexTimestamp struct {
+	Version uint64    `json:"version"`
+	Expires time.Time `json:"expires"`
+	Bsum    string    `json:"bsum,omitempty"`
+}
+
+// indexDelta turns the index at version From into the one at version To, see repoDelta.go in dbin
+type indexDelta struct {
+	From    uint64                      `json:"from"`
+	To      uint64                      `json:"to"`
+	Bsum    string                      `json:"bsum"`
+	Added   map[string][]addedEntry     `json:"added,omitempty"`
+	Changed map[string][]map[string]any `json:"changed,omitempty"`
+	Removed map[string][]string         `json:"removed,omitempty"`
+}
+
+type addedEntry struct {
+	After string         `json:"after,omitempty"`
+	Entry map[string]any `json:"entry"`
+}
+
+type genericIndex map[string][]map[string]any
+
 type repository struct {
 	URLs       []string
 	Name       string
@@ -430,6 +461,10 @@ func reorderItems(str []map[string]string, metadata DbinMetadata) {
 }
 
 func saveAll(filename string, metadata DbinMetadata) error {
+	// Must run before the previous index is overwritten
+	if err := saveDelta(filename, metadata); err != nil {
+		fmt.Printf("%swarning:%s No delta saved for %s: %v\n", colorYellow, colorReset, filename, err)
+	}
 	if err := saveJSON(filename, metadata); err != nil {
 		return err
 	}
@@ -496,12 +531,172 @@ func saveMetadata(filename string, metadata DbinMetadata) error {
 	return saveAll(filename+".lite", metadata)
 }
 
+// saveTimestamp writes the timestamp document of an index file, see repoTimestamp.go in dbin
+func saveTimestamp(path string, data []byte) error {
+	sum := blake3.Sum256(data)
+	tsData, err := json.Marshal(indexTimestamp{
+		Version: indexVersion,
+		Expires: time.Now().Add(indexLifetime).UTC(),
+		Bsum:    fmt.Sprintf("%x", sum[:]),
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".timestamp.json", tsData, 0644)
+}
+
+// canonical is the form dbin checks the result of applying deltas against: compact JSON with sorted keys,
+// without the repositories that have no entries
+func (index genericIndex) canonical() (string, error) {
+	nonEmpty := make(genericIndex, len(index))
+	for repoName, entries := range index {
+		if len(entries) > 0 {
+			nonEmpty[repoName] = entries
+		}
+	}
+	data, err := json.Marshal(nonEmpty)
+	if err != nil {
+		return "", err
+	}
+	sum := blake3.Sum256(data)
+	return fmt.Sprintf("%x", sum[:]), nil
+}
+
+func entryKey(entry map[string]any) string {
+	pkg, _ := entry["pkg"].(string)
+	pkgID, _ := entry["pkg_id"].(string)
+	return pkg + "#" + pkgID
+}
+
+// keyEntries maps the key of every entry of entries to it, keys must be unique for deltas to apply
+func keyEntries(entries []map[string]any) (map[string]map[string]any, error) {
+	keyed := make(map[string]map[string]any, len(entries))
+	for _, entry := range entries {
+		key := entryKey(entry)
+		if _, ok := keyed[key]; ok {
+			return nil, fmt.Errorf("%s appears more than once", key)
+		}
+		keyed[key] = entry
+	}
+	return keyed, nil
+}
+
+// saveDelta writes the delta from the index previously saved as filename (if any) to metadata, as
+// filename.delta.<previous version>.json, and removes the deltas that start at expired versions
+func saveDelta(filename string, metadata DbinMetadata) error {
+	tsData, err := os.ReadFile(filename + ".json.timestamp.json")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var previous indexTimestamp
+	if err := json.Unmarshal(tsData, &previous); err != nil {
+		return err
+	}
+	if previous.Version == 0 || previous.Version >= indexVersion {
+		return nil
+	}
+
+	var oldIndex, newIndex genericIndex
+	oldData, err := os.ReadFile(filename + ".json")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(oldData, &oldIndex); err != nil {
+		return err
+	}
+	newData, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(newData, &newIndex); err != nil {
+		return err
+	}
+
+	delta := indexDelta{
+		From:    previous.Version,
+		To:      indexVersion,
+		Added:   make(map[string][]addedEntry),
+		Changed: make(map[string][]map[string]any),
+		Removed: make(map[string][]string),
+	}
+	if delta.Bsum, err = newIndex.canonical(); err != nil {
+		return err
+	}
+
+	for repoName, entries := range oldIndex {
+		oldEntries, err := keyEntries(entries)
+		if err != nil {
+			return fmt.Errorf("%s: %v", repoName, err)
+		}
+		newEntries, err := keyEntries(newIndex[repoName])
+		if err != nil {
+			return fmt.Errorf("%s: %v", repoName, err)
+		}
+		for _, entry := range entries {
+			if _, ok := newEntries[entryKey(entry)]; !ok {
+				delta.Removed[repoName] = append(delta.Removed[repoName], entryKey(entry))
+			}
+		}
+		for _, entry := range newIndex[repoName] {
+			old, ok := oldEntries[entryKey(entry)]
+			if !ok {
+				continue
+			}
+			oldJSON, _ := json.Marshal(old)
+			newJSON, _ := json.Marshal(entry)
+			if string(oldJSON) != string(newJSON) {
+				delta.Changed[repoName] = append(delta.Changed[repoName], entry)
+			}
+		}
+	}
+	for repoName, entries := range newIndex {
+		oldEntries, err := keyEntries(oldIndex[repoName])
+		if err != nil {
+			return fmt.Errorf("%s: %v", repoName, err)
+		}
+		if _, err := keyEntries(entries); err != nil {
+			return fmt.Errorf("%s: %v", repoName, err)
+		}
+		// Added entries go right after the entry that precedes them in the new index
+		after := ""
+		for _, entry := range entries {
+			if _, ok := oldEntries[entryKey(entry)]; !ok {
+				delta.Added[repoName] = append(delta.Added[repoName], addedEntry{After: after, Entry: entry})
+			}
+			after = entryKey(entry)
+		}
+	}
+
+	deltaData, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(fmt.Sprintf("%s.delta.%d.json", filename, previous.Version), deltaData, 0644); err != nil {
+		return err
+	}
+
+	// Versions are unix timestamps, nobody still has an index that expired long ago
+	oldDeltas, _ := filepath.Glob(filename + ".delta.*.json")
+	for _, path := range oldDeltas {
+		from, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(path, filename+".delta."), ".json"), 10, 64)
+		if err == nil && time.Unix(int64(from), 0).Before(time.Now().Add(-indexLifetime)) {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
 func saveCBOR(filename string, metadata DbinMetadata) error {
 	cborData, err := cbor.Marshal(metadata)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename+".cbor", cborData, 0644)
+	if err := os.WriteFile(filename+".cbor", cborData, 0644); err != nil {
+		return err
+	}
+	return saveTimestamp(filename+".cbor", cborData)
 }
 func saveJSON(filename string, metadata DbinMetadata) error {
 	jsonData, err := json.MarshalIndent(metadata, "", " ")
@@ -511,6 +706,9 @@ func saveJSON(filename string, metadata DbinMetadata) error {
 	if err := os.WriteFile(filename+".json", jsonData, 0644); err != nil {
 		return err
 	}
+	if err := saveTimestamp(filename+".json", jsonData); err != nil {
+		return err
+	}
 	// Minify JSON
 	m := minify.New()
 	m.AddFunc("application/json", mjson.Minify)
@@ -519,7 +717,7 @@ func saveJSON(filename string, metadata DbinMetadata) error {
 	} else if err := os.WriteFile(filename+".min.json", jsonData, 0644); err != nil {
 		return err
 	}
-	return nil
+	return saveTimestamp(filename+".min.json", jsonData)
 }
 
 func main() {
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the indexes and deltas dbin's tests apply (testdata/delta)")
+
+// deltaFixtures is where dbin's tests read the indexes and deltas written here from
+const deltaFixtures = "../../../../testdata/delta"
+
+// fixtureVersions are three versions of an index, each one adding, changing and removing entries
+func fixtureVersions() []DbinMetadata {
+	item := func(pkg, pkgID, version string) DbinItem {
+		return DbinItem{Pkg: pkg, Name: pkg, PkgId: pkgID, Version: version, DownloadURL: "https://example.com/" + pkg, Bsum: fmt.Sprintf("%064x", len(pkg+version))}
+	}
+	return []DbinMetadata{
+		{
+			"bincache": {item("bat", "bat#musl", "0.24.0"), item("busybox", "busybox#musl", "1.36"), item("curl", "curl#musl", "8.9")},
+			"pkgcache": {item("xz", "xz#glibc", "5.6")},
+		},
+		{
+			"bincache": {item("bat", "bat#musl", "0.24.0"), item("btop", "btop#musl", "1.4"), item("busybox", "busybox#musl", "1.37")},
+			"pkgcache": {item("xz", "xz#glibc", "5.6")},
+			"extra":    {item("jq", "jq#musl", "1.7")},
+		},
+		{
+			"bincache": {item("age", "age#musl", "1.2"), item("bat", "bat#musl", "0.25.0"), item("btop", "btop#musl", "1.4"), item("busybox", "busybox#musl", "1.37")},
+			"pkgcache": {},
+			"extra":    {item("jq", "jq#musl", "1.7.1")},
+		},
+	}
+}
+
+// TestSaveDeltaFixtures writes every version of fixtureVersions as the generator would, and checks that the
+// first and last index and the deltas between them are still the ones dbin's tests apply (run with -update to
+// rewrite them)
+func TestSaveDeltaFixtures(t *testing.T) {
+	defer func(version uint64, lifetime time.Duration) {
+		indexVersion, indexLifetime = version, lifetime
+	}(indexVersion, indexLifetime)
+	// Deltas from versions that expired are removed, these ones never do
+	indexLifetime = 100 * 365 * 24 * time.Hour
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "index")
+	for i, metadata := range fixtureVersions() {
+		indexVersion = uint64(1001 + i)
+		if err := saveDelta(filename, metadata); err != nil {
+			t.Fatal(err)
+		}
+		if err := saveJSON(filename, metadata); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filename + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fmt.Sprintf("%s.%d.json", filename, indexVersion), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"index.1001.json", "index.1003.json", "index.delta.1001.json", "index.delta.1002.json"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixture := filepath.Join(deltaFixtures, name)
+		if *update {
+			if err := os.MkdirAll(deltaFixtures, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(fixture, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from what the generator writes now, rewrite it with -update", fixture)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+	"golang.org/x/net/http/httpproxy"
+)
+
+var (
+	errNetworkConfig = errs.Class("invalid network config")
+	httpClientsMu    sync.Mutex
+	httpClients      = make(map[string]*http.Client)
+)
+
+type networkConfig struct {
+	Proxy          string            `yaml:"Proxy,omitempty" description:"Proxy used for every request. HTTPS_PROXY and HTTP_PROXY are used if unset."`
+	NoProxy        string            `yaml:"NoProxy,omitempty" description:"Comma-separated list of hosts that are reached without the proxy. NO_PROXY is used if unset."`
+	CAFile         string            `yaml:"CAFile,omitempty" description:"PEM file with CA certificates to trust on top of the system (or built-in) ones."`
+	ConnectTimeout time.Duration     `yaml:"ConnectTimeout,omitempty" description:"Give up on connections that can't be established within this time."`
+	ReadTimeout    time.Duration     `yaml:"ReadTimeout,omitempty" description:"Give up on connections that stay silent for this long."`
+	Headers        map[string]string `yaml:"Headers,omitempty" description:"Headers added to every request."`
+}
+
+// mergeNetworkConfig returns base with the fields that are set in override replacing its own
+func mergeNetworkConfig(base networkConfig, override *networkConfig) networkConfig {
+	if override == nil {
+		return base
+	}
+	merged := base
+	if override.Proxy != "" {
+		merged.Proxy = override.Proxy
+	}
+	if override.NoProxy != "" {
+		merged.NoProxy = override.NoProxy
+	}
+	if override.CAFile != "" {
+		merged.CAFile = override.CAFile
+	}
+	if override.ConnectTimeout != 0 {
+		merged.ConnectTimeout = override.ConnectTimeout
+	}
+	if override.ReadTimeout != 0 {
+		merged.ReadTimeout = override.ReadTimeout
+	}
+	if len(override.Headers) > 0 {
+		merged.Headers = make(map[string]string, len(base.Headers)+len(override.Headers))
+		for k, v := range base.Headers {
+			merged.Headers[k] = v
+		}
+		for k, v := range override.Headers {
+			merged.Headers[k] = v
+		}
+	}
+	return merged
+}
+
+// httpClient returns the client for requests made on behalf of repo, or of no repository in particular if
+// it is nil. Clients are built once, so that connections are reused across the whole run
+func httpClient(cfg *config, repo *repository) *http.Client {
+	key := ""
+	network := cfg.Network
+	if repo != nil && (repo.Network != nil || repo.Auth != nil) {
+		key = repo.URL
+		network = mergeNetworkConfig(cfg.Network, repo.Network)
+	}
+
+	httpClientsMu.Lock()
+	defer httpClientsMu.Unlock()
+
+	if client, ok := httpClients[key]; ok {
+		return client
+	}
+
+	transport, err := newTransport(network)
+	if err != nil {
+		// The config is validated when it is loaded, this only happens if the CA file went away since then
+		if verbosityLevel >= silentVerbosityWithErrors {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		transport = http.DefaultTransport
+	}
+
+	transport = &headerTransport{headers: network.Headers, next: transport}
+	if repo != nil && repo.Auth != nil {
+		transport = newAuthTransport(repo, transport)
+	}
+
+	client := &http.Client{Transport: transport}
+	httpClients[key] = client
+	return client
+}
+
+func newTransport(network networkConfig) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxyConfig := httpproxy.FromEnvironment()
+	if network.Proxy != "" {
+		proxyConfig.HTTPProxy = network.Proxy
+		proxyConfig.HTTPSProxy = network.Proxy
+	}
+	if network.NoProxy != "" {
+		proxyConfig.NoProxy = network.NoProxy
+	}
+	proxyFunc := proxyConfig.ProxyFunc()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+
+	dialer := &net.Dialer{Timeout: network.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, proto, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, proto, addr)
+		if err != nil || network.ReadTimeout <= 0 {
+			return conn, err
+		}
+		return &idleTimeoutConn{Conn: conn, timeout: network.ReadTimeout}, nil
+	}
+	transport.TLSHandshakeTimeout = network.ConnectTimeout
+	transport.ResponseHeaderTimeout = network.ReadTimeout
+
+	if network.CAFile != "" {
+		pool, err := loadCertPool(network.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return transport, nil
+}
+
+// loadCertPool returns the system pool (or the built-in one, see breml/rootcerts) along with the certificates in caFile
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, errNetworkConfig.Wrap(err)
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errNetworkConfig.New("%s contains no PEM certificates", caFile)
+	}
+	return pool, nil
+}
+
+// validateNetworkConfig catches mistakes in the Network sections before any request is made
+func validateNetworkConfig(cfg *config) error {
+	networks := []networkConfig{cfg.Network}
+	for _, repo := range cfg.Repositories {
+		if repo.Network != nil {
+			networks = append(networks, mergeNetworkConfig(cfg.Network, repo.Network))
+		}
+	}
+	for _, network := range networks {
+		if network.Proxy != "" {
+			if _, err := url.Parse(network.Proxy); err != nil {
+				return errNetworkConfig.New("Proxy: %v", err)
+			}
+		}
+		if network.CAFile != "" {
+			if _, err := loadCertPool(network.CAFile); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// headerTransport adds the configured headers to the requests that don't set them already
+type headerTransport struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) > 0 {
+		req = req.Clone(req.Context())
+		for k, v := range t.headers {
+			if req.Header.Get(k) == "" {
+				req.Header.Set(k, v)
+			}
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// idleTimeoutConn fails reads and writes that make no progress for timeout, which
+// catches stalled downloads without putting a limit on how long a download may take
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(p)
+}
+
+func (c *idleTimeoutConn) Write(p []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/adrg/xdg"
+	"github.com/goccy/go-json"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errRegistryAuth = errs.Class("registry authentication failed")
+)
+
+// ociClient talks to one repository of a registry, answering its authentication challenges.
+// It may be used by several goroutines at once, like the segments of a download
+type ociClient struct {
+	cfg        *config
+	client     *http.Client
+	registry   string
+	repository string
+
+	mu            sync.Mutex
+	authorization string
+}
+
+func newOCIClient(cfg *config, client *http.Client, registry, repository string) *ociClient {
+	return &ociClient{cfg: cfg, client: client, registry: registry, repository: repository}
+}
+
+// registryBaseURL returns where the API of registry is served. Registries on the loopback
+// interface are spoken to over plain HTTP, like docker does by default
+func registryBaseURL(registry string) string {
+	switch registry {
+	case "docker.io", "index.docker.io":
+		registry = "registry-1.docker.io"
+	}
+	if isLoopback(registry) {
+		return "http://" + registry
+	}
+	return "https://" + registry
+}
+
+// realmTrusted reports whether the credentials for the registry may be sent to the token realm it advertised: only
+// over HTTPS, unless the registry is on the loopback interface, and only to the registry or the hosts allowed to
+// serve its tokens
+func (c *ociClient) realmTrusted(realm *url.URL) bool {
+	base, err := url.Parse(registryBaseURL(c.registry))
+	if err != nil || (realm.Scheme != "https" && realm.Scheme != base.Scheme) {
+		return false
+	}
+	allowed := append([]string{base.Host}, c.cfg.RegistryTokenHosts...)
+	switch c.registry {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		allowed = append(allowed, "auth.docker.io")
+	}
+	return hostAllowed(allowed, realm)
+}
+
+func (c *ociClient) getAuthorization() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authorization
+}
+
+func (c *ociClient) setAuthorization(authorization string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authorization = authorization
+}
+
+func (c *ociClient) url(kind, reference string) string {
+	return fmt.Sprintf("%s/v2/%s/%s/%s", registryBaseURL(c.registry), c.repository, kind, reference)
+}
+
+// do sends req, authenticating and sending it again if the registry answers with a challenge.
+// Failed requests are transient errors, failing to authenticate is not
+func (c *ociClient) do(req *http.Request) (*http.Response, error) {
+	if authorization := c.getAuthorization(); authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, transientRequestError(req.Context(), err)
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err := c.authenticate(req.Context(), challenge); err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", c.getAuthorization())
+	resp, err = c.client.Do(retry)
+	return resp, transientRequestError(req.Context(), err)
+}
+
+// parseChallenge splits a WWW-Authenticate header (e.g: Bearer realm="...",service="...",scope="...")
+func parseChallenge(header string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(rest, ", ") {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end == -1 {
+				params[key] = value[1:]
+				break
+			}
+			params[key], rest = value[1:end+1], value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+	}
+	return scheme, params
+}
+
+func (c *ociClient) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	username, password, found, err := registryCredentials(c.registry, c.repository)
+	if err != nil {
+		return errRegistryAuth.Wrap(err)
+	}
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !found {
+			return errRegistryAuth.New("%s requires credentials, but there are none for it in the docker or podman auth files", c.registry)
+		}
+		c.setAuthorization("Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+		return nil
+	case "bearer":
+		token, err := c.fetchToken(ctx, params, username, password, found)
+		if err != nil {
+			return err
+		}
+		c.setAuthorization("Bearer " + token)
+		return nil
+	}
+	return errRegistryAuth.New("%s: unsupported authentication challenge %q", c.registry, challenge)
+}
+
+// fetchToken gets a token from the realm advertised by the registry, anonymously if there are no credentials for it
+// or if the realm can't be trusted with them
+func (c *ociClient) fetchToken(ctx context.Context, params map[string]string, username, password string, withCredentials bool) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", errRegistryAuth.New("%s: challenge has no valid realm", c.registry)
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", ternary(params["scope"] != "", params["scope"], "repository:"+c.repository+":pull"))
+	realm.RawQuery = query.Encode()
+
+	if withCredentials && !c.realmTrusted(realm) {
+		if verbosityLevel >= silentVerbosityWithErrors {
+			fmt.Fprintf(os.Stderr, "Warning: %s sent dbin to %s://%s for a token, asking for one without your credentials\n", c.registry, realm.Scheme, realm.Host)
+		}
+		withCredentials = false
+	}
+
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = withRetries(ctx, c.cfg, realm.String(), func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", realm.String(), nil)
+		if err != nil {
+			return errAuthToken.Wrap(err)
+		}
+		if withCredentials {
+			req.SetBasicAuth(username, password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return errAuthToken.Wrap(transientRequestError(ctx, err))
+		}
+		defer resp.Body.Close()
+
+		if err := checkStatus(resp, http.StatusOK); err != nil {
+			return errAuthToken.Wrap(err)
+		}
+		return errAuthToken.Wrap(json.NewDecoder(resp.Body).Decode(&tokenResponse))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	token := ternary(tokenResponse.Token != "", tokenResponse.Token, tokenResponse.AccessToken)
+	if token == "" {
+		return "", errAuthToken.New("%s returned no token", realm.Host)
+	}
+	return token, nil
+}
+
+// containerAuthFile is the format shared by docker's config.json and podman's auth.json
+type containerAuthFile struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// containerAuthFiles lists the auth files in the order podman looks them up, docker's last
+func containerAuthFiles() []string {
+	var files []string
+	if path := os.Getenv("REGISTRY_AUTH_FILE"); path != "" {
+		files = append(files, path)
+	}
+	if xdg.RuntimeDir != "" {
+		files = append(files, filepath.Join(xdg.RuntimeDir, "containers", "auth.json"))
+	}
+	files = append(files, filepath.Join(xdg.ConfigHome, "containers", "auth.json"))
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		files = append(files, filepath.Join(dir, "config.json"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".docker", "config.json"))
+	}
+	return files
+}
+
+// authKeys returns the keys under which the credentials for repository may be found, most specific first
+func authKeys(registry, repository string) []string {
+	var keys []string
+	for path := registry + "/" + repository; strings.Contains(path, "/"); path = path[:strings.LastIndex(path, "/")] {
+		keys = append(keys, path)
+	}
+	keys = append(keys, registry)
+	switch registry {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		keys = append(keys, "https://index.docker.io/v1/", "index.docker.io", "docker.io")
+	}
+	return keys
+}
+
+// credsStoreServerURL returns the server URL that docker stores the credentials of registry under in
+// its credsStore, which for Docker Hub is the URL of its old index
+func credsStoreServerURL(registry string) string {
+	switch registry {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		return "https://index.docker.io/v1/"
+	}
+	return registry
+}
+
+func normalizeAuthKey(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key = strings.TrimSuffix(key, "/")
+	return strings.TrimSuffix(strings.TrimSuffix(key, "/v1"), "/v2")
+}
+
+// registryCredentials looks for the credentials of registry in the docker and podman auth files,
+// and in the docker credential helpers they point to
+func registryCredentials(registry, repository string) (username, password string, found bool, err error) {
+	keys := authKeys(registry, repository)
+
+	for _, path := range containerAuthFiles() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var authFile containerAuthFile
+		if err := json.Unmarshal(data, &authFile); err != nil {
+			return "", "", false, errRegistryAuth.New("%s: %v", path, err)
+		}
+
+		for _, key := range keys {
+			if helper, ok := authFile.CredHelpers[key]; ok {
+				return credentialHelperGet(helper, key)
+			}
+		}
+
+		for _, key := range keys {
+			for authKey, auth := range authFile.Auths {
+				if normalizeAuthKey(authKey) != normalizeAuthKey(key) {
+					continue
+				}
+				if auth.Auth != "" {
+					decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+					if err != nil {
+						return "", "", false, errRegistryAuth.New("%s: auth for %s: %v", path, authKey, err)
+					}
+					username, password, _ = strings.Cut(string(decoded), ":")
+					return username, password, true, nil
+				}
+				if auth.Username != "" {
+					return auth.Username, auth.Password, true, nil
+				}
+			}
+		}
+
+		if authFile.CredsStore != "" {
+			if username, password, found, err := credentialHelperGet(authFile.CredsStore, credsStoreServerURL(registry)); err != nil || found {
+				return username, password, found, err
+			}
+		}
+	}
+
+	return "", "", false, nil
+}
+
+// credentialHelperGet asks docker-credential-<helper> for the credentials of serverURL
+func credentialHelperGet(helper, serverURL string) (username, password string, found bool, err error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(string(out)+stderr.String(), "credentials not found") {
+			return "", "", false, nil
+		}
+		return "", "", false, errRegistryAuth.New("docker-credential-%s: %v", helper, err)
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return "", "", false, errRegistryAuth.New("docker-credential-%s: %v", helper, err)
+	}
+	return creds.Username, creds.Secret, true, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+// testRegistry serves the manifests of org/app behind a bearer token, like ghcr.io and Docker Hub do
+type testRegistry struct {
+	*httptest.Server
+	manifests   map[string][]byte // by tag or digest
+	tokens      atomic.Int32
+	credentials string // user:password the token endpoint expects, if any
+	realm       string // where tokens are served, if not by the registry itself
+}
+
+func manifestDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	t.Helper()
+	reg := &testRegistry{manifests: make(map[string][]byte)}
+
+	var platforms []ociDescriptor
+	for _, arch := range []string{"amd64", "arm64"} {
+		body, err := json.Marshal(ociManifest{
+			MediaType: mediaTypeOCIManifest,
+			Layers: []ociDescriptor{{
+				MediaType:   "application/octet-stream",
+				Digest:      "sha256:" + strings.Repeat(arch[len(arch)-1:], 64),
+				Annotations: map[string]string{ociTitleAnnotation: "app-" + arch},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := manifestDigest(body)
+		reg.manifests[digest] = body
+		platforms = append(platforms, ociDescriptor{MediaType: mediaTypeOCIManifest, Digest: digest, Platform: &ociPlatform{OS: "linux", Architecture: arch}})
+	}
+	index, err := json.Marshal(ociManifest{MediaType: mediaTypeOCIIndex, Manifests: platforms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.manifests["latest"] = index
+
+	reg.Server = httptest.NewServer(http.HandlerFunc(reg.serve))
+	t.Cleanup(reg.Close)
+	return reg
+}
+
+func (reg *testRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		if r.URL.Query().Get("scope") != "repository:org/app:pull" {
+			http.Error(w, "bad scope", http.StatusBadRequest)
+			return
+		}
+		if reg.credentials != "" {
+			username, password, ok := r.BasicAuth()
+			if !ok || username+":"+password != reg.credentials {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+		}
+		reg.tokens.Add(1)
+		w.Write([]byte(`{"token":"t0ken"}`))
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer t0ken" {
+		realm := ternary(reg.realm != "", reg.realm, reg.URL+"/token")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`",service="test",scope="repository:org/app:pull"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	reference, ok := strings.CutPrefix(r.URL.Path, "/v2/org/app/manifests/")
+	body, found := reg.manifests[reference]
+	if !ok || !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(body)
+}
+
+func (reg *testRegistry) client() *ociClient {
+	return newOCIClient(&config{RetryAttempts: 1}, reg.Client(), strings.TrimPrefix(reg.URL, "http://"), "org/app")
+}
+
+func TestOCIAuthenticationAndPlatformSelection(t *testing.T) {
+	t.Setenv("REGISTRY_AUTH_FILE", filepath.Join(t.TempDir(), "none.json"))
+	reg := newTestRegistry(t)
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		t.Run(arch, func(t *testing.T) {
+			manifest, err := resolveManifest(context.Background(), reg.client(), "latest", parsePlatform("linux/"+arch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(manifest.Layers) != 1 || manifest.Layers[0].Annotations[ociTitleAnnotation] != "app-"+arch {
+				t.Errorf("picked %+v, want the manifest of linux/%s", manifest.Layers, arch)
+			}
+			if want := manifestDigest(reg.manifests["latest"]); len(manifest.indexes) != 1 || manifest.indexes[0] != want {
+				t.Errorf("indexes = %q, want [%s]", manifest.indexes, want)
+			}
+		})
+	}
+
+	_, err := resolveManifest(context.Background(), reg.client(), "latest", parsePlatform("linux/riscv64"))
+	if !errNoMatchingPlatform.Has(err) {
+		t.Errorf("resolving for riscv64: %v, want errNoMatchingPlatform", err)
+	}
+}
+
+func TestOCIAuthenticationIsShared(t *testing.T) {
+	t.Setenv("REGISTRY_AUTH_FILE", filepath.Join(t.TempDir(), "none.json"))
+	reg := newTestRegistry(t)
+	oci := reg.client()
+
+	// The first request answers the challenge, the ones that follow reuse its token
+	if _, err := downloadManifest(context.Background(), oci, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := downloadManifest(context.Background(), oci, "latest"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := reg.tokens.Load(); n != 1 {
+		t.Errorf("fetched %d tokens, want 1", n)
+	}
+}
+
+func TestOCIAuthenticationWithCredentials(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.credentials = "user:secret"
+
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	auth := `{"auths":{"` + strings.TrimPrefix(reg.URL, "http://") + `":{"username":"user","password":"secret"}}}`
+	if err := os.WriteFile(authFile, []byte(auth), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REGISTRY_AUTH_FILE", authFile)
+
+	if _, err := resolveManifest(context.Background(), reg.client(), "latest", parsePlatform("linux/amd64")); err != nil {
+		t.Fatal(err)
+	}
+
+	reg.credentials = "user:other"
+	if _, err := resolveManifest(context.Background(), reg.client(), "latest", parsePlatform("linux/amd64")); err == nil {
+		t.Error("authenticated with the wrong credentials")
+	}
+}
+
+func TestCredsStoreServerURL(t *testing.T) {
+	tests := map[string]string{
+		"docker.io":            "https://index.docker.io/v1/",
+		"index.docker.io":      "https://index.docker.io/v1/",
+		"registry-1.docker.io": "https://index.docker.io/v1/",
+		"ghcr.io":              "ghcr.io",
+		"localhost:5000":       "localhost:5000",
+	}
+	for registry, want := range tests {
+		if got := credsStoreServerURL(registry); got != want {
+			t.Errorf("credsStoreServerURL(%q) = %q, want %q", registry, got, want)
+		}
+	}
+}
+
+func TestOCITokenRealmOnAnotherHost(t *testing.T) {
+	var sentCredentials atomic.Bool
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, ok := r.BasicAuth()
+		sentCredentials.Store(ok)
+		w.Write([]byte(`{"token":"t0ken"}`))
+	}))
+	defer tokenServer.Close()
+
+	reg := newTestRegistry(t)
+	reg.realm = tokenServer.URL + "/token"
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	auth := `{"auths":{"` + strings.TrimPrefix(reg.URL, "http://") + `":{"username":"user","password":"secret"}}}`
+	if err := os.WriteFile(authFile, []byte(auth), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REGISTRY_AUTH_FILE", authFile)
+
+	tests := []struct {
+		name            string
+		allowed         []string
+		sendCredentials bool
+	}{
+		{"not allowed", nil, false},
+		{"allowed", []string{strings.TrimPrefix(tokenServer.URL, "http://")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oci := newOCIClient(&config{RetryAttempts: 1, RegistryTokenHosts: tt.allowed}, reg.Client(), strings.TrimPrefix(reg.URL, "http://"), "org/app")
+			if _, err := resolveManifest(context.Background(), oci, "latest", parsePlatform("linux/amd64")); err != nil {
+				t.Fatal(err)
+			}
+			if sentCredentials.Load() != tt.sendCredentials {
+				t.Errorf("sent the credentials to %s: %v, want %v", tokenServer.URL, sentCredentials.Load(), tt.sendCredentials)
+			}
+		})
+	}
+}
+
+func TestRealmTrusted(t *testing.T) {
+	tests := []struct {
+		registry, realm string
+		trusted         bool
+	}{
+		{"ghcr.io", "https://ghcr.io/token", true},
+		{"ghcr.io", "http://ghcr.io/token", false},
+		{"ghcr.io", "https://collector.example.com/token", false},
+		{"ghcr.io", "https://ghcr.io.example.com/token", false},
+		{"docker.io", "https://auth.docker.io/token", true},
+		{"quay.io", "https://auth.docker.io/token", false},
+		{"localhost:5000", "http://localhost:5000/token", true},
+		{"localhost:5000", "http://collector.example.com/token", false},
+		{"127.0.0.1:5000", "https://127.0.0.1:5000/token", true},
+	}
+	for _, tt := range tests {
+		realm, err := url.Parse(tt.realm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		oci := newOCIClient(&config{}, http.DefaultClient, tt.registry, "org/app")
+		if got := oci.realmTrusted(realm); got != tt.trusted {
+			t.Errorf("%s: realmTrusted(%s) = %v, want %v", tt.registry, tt.realm, got, tt.trusted)
+		}
+	}
+}
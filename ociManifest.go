@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errNoMatchingPlatform = errs.Class("no manifest for this platform")
+)
+
+const (
+	mediaTypeOCIManifest     = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex        = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIArtifact     = "application/vnd.oci.artifact.manifest.v1+json"
+	mediaTypeDockerManifest  = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerList      = "application/vnd.docker.distribution.manifest.list.v2+json"
+	maxIndexDepth            = 4
+	ociTitleAnnotation       = "org.opencontainers.image.title"
+	manifestAcceptMediaTypes = mediaTypeOCIManifest + ", " + mediaTypeOCIIndex + ", " + mediaTypeOCIArtifact + ", " + mediaTypeDockerManifest + ", " + mediaTypeDockerList
+)
+
+type ociPlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+type ociDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Platform     *ociPlatform      `json:"platform,omitempty"`
+}
+
+// ociManifest holds any of the documents a reference may point to: image manifests (layers),
+// artifact manifests (blobs), and image indexes or docker manifest lists (manifests)
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+	Blobs     []ociDescriptor `json:"blobs"`
+	Manifests []ociDescriptor `json:"manifests"`
+	// digest is the digest of the manifest itself, and indexes the digests of the indexes it was picked from
+	digest  string
+	indexes []string
+}
+
+func (m *ociManifest) isIndex() bool {
+	switch m.MediaType {
+	case mediaTypeOCIIndex, mediaTypeDockerList:
+		return true
+	case "":
+		return len(m.Manifests) > 0 && len(m.Layers) == 0 && len(m.Blobs) == 0
+	}
+	return false
+}
+
+// files returns the layers of an image manifest, or the blobs of an artifact manifest
+func (m *ociManifest) files() []ociDescriptor {
+	return append(append([]ociDescriptor(nil), m.Layers...), m.Blobs...)
+}
+
+// parsePlatform understands "arch", "arch/variant", "os/arch" and "os/arch/variant". An empty
+// string is the host's platform
+func parsePlatform(s string) ociPlatform {
+	platform := ociPlatform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(s)), "/")
+	switch {
+	case s == "":
+	case len(parts) == 1:
+		platform.Architecture = parts[0]
+	case len(parts) == 2 && strings.HasPrefix(parts[1], "v"):
+		platform.Architecture, platform.Variant = parts[0], parts[1]
+	case len(parts) == 2:
+		platform.OS, platform.Architecture = parts[0], parts[1]
+	default:
+		platform.OS, platform.Architecture, platform.Variant = parts[0], parts[1], parts[2]
+	}
+	switch platform.Architecture {
+	case "x86_64", "x86-64":
+		platform.Architecture = "amd64"
+	case "aarch64":
+		platform.Architecture = "arm64"
+	}
+	return platform
+}
+
+func (p ociPlatform) String() string {
+	return strings.TrimSuffix(p.OS+"/"+p.Architecture+"/"+p.Variant, "/")
+}
+
+// matches reports whether a manifest built for other runs on p. Variants are only
+// compared if p asks for one, arm64 is v8 when unspecified
+func (p ociPlatform) matches(other *ociPlatform) bool {
+	if other == nil || other.OS != p.OS || other.Architecture != p.Architecture {
+		return false
+	}
+	if p.Variant == "" {
+		return true
+	}
+	variant := other.Variant
+	if variant == "" && other.Architecture == "arm64" {
+		variant = "v8"
+	}
+	return variant == p.Variant
+}
+
+// selectManifest picks the entry of an index that was built for platform
+func selectManifest(index *ociManifest, platform ociPlatform) (ociDescriptor, error) {
+	var available []string
+	for _, desc := range index.Manifests {
+		if platform.matches(desc.Platform) {
+			return desc, nil
+		}
+		if desc.Platform != nil {
+			available = append(available, desc.Platform.String())
+		}
+	}
+	return ociDescriptor{}, errNoMatchingPlatform.New("%s is not among the platforms of the image: %s", platform, strings.Join(available, ", "))
+}
+
+// downloadManifest fetches the manifest that reference (a tag or a digest) points to. Manifests that are
+// requested by digest are checked against it
+func downloadManifest(ctx context.Context, oci *ociClient, reference string) (*ociManifest, error) {
+	url := oci.url("manifests", reference)
+
+	var manifest *ociManifest
+	err := withRetries(ctx, oci.cfg, url, func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return errManifestDownload.Wrap(err)
+		}
+		req.Header.Set("Accept", manifestAcceptMediaTypes)
+
+		resp, err := oci.do(req)
+		if err != nil {
+			return errManifestDownload.Wrap(err)
+		}
+		defer resp.Body.Close()
+
+		if err := checkStatus(resp, http.StatusOK); err != nil {
+			return errManifestDownload.Wrap(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return errManifestDownload.Wrap(transientRequestError(ctx, err))
+		}
+
+		manifest = &ociManifest{}
+		if err := json.Unmarshal(body, manifest); err != nil {
+			return errManifestDownload.Wrap(err)
+		}
+		if manifest.MediaType == "" {
+			manifest.MediaType, _, _ = strings.Cut(resp.Header.Get("Content-Type"), ";")
+		}
+		sum := sha256.Sum256(body)
+		manifest.digest = "sha256:" + hex.EncodeToString(sum[:])
+		if strings.HasPrefix(reference, "sha256:") && !strings.EqualFold(reference, manifest.digest) {
+			return errChecksumMismatch.New("manifest expected %s, got %s", reference, manifest.digest)
+		}
+		return nil
+	})
+	return manifest, err
+}
+
+// resolveManifest downloads the manifest that reference points to, going through
+// image indexes and manifest lists to the manifest built for platform
+func resolveManifest(ctx context.Context, oci *ociClient, reference string, platform ociPlatform) (*ociManifest, error) {
+	manifest, err := downloadManifest(ctx, oci, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []string
+	for depth := 0; manifest.isIndex(); depth++ {
+		if depth == maxIndexDepth {
+			return nil, errManifestDownload.New("%s: image indexes are nested too deeply", reference)
+		}
+		desc, err := selectManifest(manifest, platform)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, manifest.digest)
+		if manifest, err = downloadManifest(ctx, oci, desc.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest.indexes = indexes
+	return manifest, nil
+}
+
+// referrers lists the manifests that refer to digest through their subject, using the
+// referrers API, or the tag schema (sha256-<hex>) of registries that lack it
+func referrers(ctx context.Context, oci *ociClient, digest string) ([]ociDescriptor, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", oci.url("referrers", digest), nil)
+	if err != nil {
+		return nil, errManifestDownload.Wrap(err)
+	}
+	req.Header.Set("Accept", mediaTypeOCIIndex)
+
+	resp, err := oci.do(req)
+	if err != nil {
+		return nil, errManifestDownload.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		index, err := downloadManifest(ctx, oci, strings.Replace(digest, ":", "-", 1))
+		var statusErr *statusError
+		if errors.As(err, &statusErr) && statusErr.code == http.StatusNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return index.Manifests, nil
+	}
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, errManifestDownload.Wrap(err)
+	}
+
+	var referrersIndex ociManifest
+	if err := json.NewDecoder(resp.Body).Decode(&referrersIndex); err != nil {
+		return nil, errManifestDownload.Wrap(err)
+	}
+	return referrersIndex.Manifests, nil
+}
+
+type layerDigests struct {
+	binary, signature, license string
+}
+
+func findLayerDigests(files []ociDescriptor, title, titleNoExt string, digests *layerDigests) {
+	for _, file := range files {
+		switch file.Annotations[ociTitleAnnotation] {
+		case "":
+		case title, titleNoExt:
+			digests.binary = ternary(digests.binary != "", digests.binary, file.Digest)
+		case title + ".sig", titleNoExt + ".sig":
+			digests.signature = ternary(digests.signature != "", digests.signature, file.Digest)
+		case "LICENSE", titleNoExt + ".LICENSE":
+			digests.license = ternary(digests.license != "", digests.license, file.Digest)
+		}
+	}
+}
+
+// ociLayerDigests finds the files of title in manifest. The signature and license may also come from
+// artifacts that refer to the manifest (or to the index it was picked from), those are looked up on a best-effort basis
+func ociLayerDigests(ctx context.Context, oci *ociClient, manifest *ociManifest, title string, cfg *config) (layerDigests, error) {
+	var digests layerDigests
+	titleNoExt := strings.TrimSuffix(title, filepath.Ext(title))
+
+	findLayerDigests(manifest.files(), title, titleNoExt, &digests)
+	if digests.binary == "" {
+		return digests, errOCILayerDownload.New("file with title '%s' not found in manifest", title)
+	}
+
+	for _, subject := range append([]string{manifest.digest}, manifest.indexes...) {
+		if digests.signature != "" && (digests.license != "" || !cfg.CreateLicenses) {
+			break
+		}
+		descs, err := referrers(ctx, oci, subject)
+		if err != nil {
+			if verbosityLevel >= extraVerbose {
+				fmt.Fprintf(os.Stderr, "Warning: could not list the referrers of %s: %v\n", subject, err)
+			}
+			continue
+		}
+		for _, desc := range descs {
+			referrer, err := downloadManifest(ctx, oci, desc.Digest)
+			if err != nil {
+				if verbosityLevel >= extraVerbose {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+				continue
+			}
+			findLayerDigests(referrer.files(), title, titleNoExt, &digests)
+		}
+	}
+
+	return digests, nil
+}
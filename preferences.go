@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path"
+	"slices"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	errInvalidPreference = errs.Class("invalid preference")
+)
+
+// preferences rank the variants of a package (e.g: musl, glibc, upx) by their pkg_id, ahead of their rank and
+// the order the index generator sorts them in
+type preferences struct {
+	Prefer []string `yaml:"prefer,omitempty" description:"Glob patterns over pkg_id, variants that match one are picked first, the earlier the pattern, the more so."`
+	Avoid  []string `yaml:"avoid,omitempty" description:"Glob patterns over pkg_id, variants that match one are picked last, the earlier the pattern, the more so."`
+}
+
+func (p preferences) empty() bool {
+	return len(p.Prefer) == 0 && len(p.Avoid) == 0
+}
+
+// firstMatch returns the position of the first of patterns that pkgID matches, or -1
+func firstMatch(patterns []string, pkgID string) int {
+	for i, pattern := range patterns {
+		if matched, _ := path.Match(pattern, pkgID); matched {
+			return i
+		}
+	}
+	return -1
+}
+
+// rank returns where pkgID stands according to p, lower is better. Being avoided outweighs being preferred
+func (p preferences) rank(pkgID string) int {
+	avoided := 0
+	if i := firstMatch(p.Avoid, pkgID); i != -1 {
+		avoided = len(p.Avoid) - i
+	}
+	preferred := len(p.Prefer)
+	if i := firstMatch(p.Prefer, pkgID); i != -1 {
+		preferred = i
+	}
+	return avoided*(len(p.Prefer)+1) + preferred
+}
+
+// describe tells which pattern of p pkgID matches
+func (p preferences) describe(pkgID string) string {
+	if i := firstMatch(p.Avoid, pkgID); i != -1 {
+		return "avoided (" + p.Avoid[i] + ")"
+	}
+	if i := firstMatch(p.Prefer, pkgID); i != -1 {
+		return "preferred (" + p.Prefer[i] + ")"
+	}
+	return "no preference"
+}
+
+func validatePreferences(cfg *config) error {
+	for _, pattern := range slices.Concat(cfg.Preferences.Prefer, cfg.Preferences.Avoid) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errInvalidPreference.New("%q: %v", pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errRepoCommand = errs.Class("repo command failed")
+)
+
+func repoCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "repo",
+		Usage: "Manage repositories",
+		Commands: []*cli.Command{
+			{
+				Name:      "trust",
+				Usage:     "Accept the current public key(s) of the given names (as in pubKeys), or of every repository",
+				ArgsUsage: "[names...]",
+				Action: func(_ context.Context, c *cli.Command) error {
+					config, err := loadConfig()
+					if err != nil {
+						return errRepoCommand.Wrap(err)
+					}
+					return trustRepoKeys(config, c.Args().Slice())
+				},
+			},
+		},
+	}
+}
+
+// trustRepoKeys fetches the keys called names anew, and records them in the trust store, replacing the old ones
+func trustRepoKeys(config *config, names []string) error {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = false
+	}
+
+	var errors []string
+	for _, repo := range config.Repositories {
+		keyNames := make([]string, 0, len(repo.PubKeys))
+		for name := range repo.PubKeys {
+			keyNames = append(keyNames, name)
+		}
+		sort.Strings(keyNames)
+
+		for _, name := range keyNames {
+			if _, ok := wanted[name]; len(names) > 0 && !ok {
+				continue
+			}
+			wanted[name] = true
+
+			pubKeyData, err := accessCachedOrFetch([]string{repo.PubKeys[name]}, pubKeyCacheName(repo, name), config, &repo, 0, nil)
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			pubKey, err := parsePublicKey(pubKeyData)
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("%s: %s: %v", name, repo.PubKeys[name], err))
+				continue
+			}
+			if err := checkPin(repo, name, pubKey); err != nil {
+				errors = append(errors, err.Error())
+				continue
+			}
+
+			oldKey, known := trustedKeyOf(config, repo, name)
+			trustStoreMu.Lock()
+			err = trustKey(config, repo, name, pubKey)
+			trustStoreMu.Unlock()
+			if err != nil {
+				return errRepoCommand.Wrap(err)
+			}
+
+			if verbosityLevel >= normalVerbosity {
+				if known && oldKey != pubKey {
+					fmt.Printf("Trusted %s for %q (replaces %s)\n", keyID(pubKey.KeyId), name, keyID(oldKey.KeyId))
+				} else {
+					fmt.Printf("Trusted %s for %q\n", keyID(pubKey.KeyId), name)
+				}
+			}
+		}
+	}
+
+	for _, name := range names {
+		if !wanted[name] {
+			errors = append(errors, fmt.Sprintf("no repository has a public key named %q", name))
+		}
+	}
+
+	if len(errors) > 0 {
+		return errRepoCommand.New("%s", strings.Join(errors, "\n"))
+	}
+	return nil
+}
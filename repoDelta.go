@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/goccy/go-json"
+	"github.com/goccy/go-yaml"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errIndexDelta = errs.Class("index delta error")
+)
+
+// maxDeltaChain is how many deltas are applied in a row, before a full download is deemed cheaper
+const maxDeltaChain = 32
+
+type (
+	genericEntry = map[string]any
+	genericIndex map[string][]genericEntry
+)
+
+// indexDelta is published next to an index file (<index>.delta.<from>.json) by the index generator, it turns
+// the index at version From (as in its timestamp document) into the index at version To
+type indexDelta struct {
+	From    uint64                    `json:"from"`
+	To      uint64                    `json:"to"`
+	Bsum    string                    `json:"bsum"` // b3sum of the canonical form of the index at To
+	Added   map[string][]addedEntry   `json:"added,omitempty"`
+	Changed map[string][]genericEntry `json:"changed,omitempty"`
+	Removed map[string][]string       `json:"removed,omitempty"`
+}
+
+// addedEntry is placed right after the entry whose key is After, or first if After is empty
+type addedEntry struct {
+	After string       `json:"after,omitempty"`
+	Entry genericEntry `json:"entry"`
+}
+
+// entryKey identifies an entry of a repository across versions of its index, the same way dbin names binaries
+func entryKey(entry genericEntry) string {
+	pkg, _ := entry["pkg"].(string)
+	pkgID, _ := entry["pkg_id"].(string)
+	return pkg + "#" + pkgID
+}
+
+// indexBaseName strips the compression and format extensions of indexURL, the variants of an index
+// in different formats (e.g: amd64_linux.nlite.cbor.zst, amd64_linux.nlite.json) share their deltas
+func indexBaseName(indexURL string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(indexURL, ".zst"), ".gz")
+	for _, ext := range []string{".min.json", ".json", ".cbor", ".yaml", ".msgp"} {
+		if trimmed, ok := strings.CutSuffix(base, ext); ok {
+			return trimmed
+		}
+	}
+	return base
+}
+
+// deltaURL returns the URL of the delta that updates the index at indexURL from version from
+func deltaURL(indexURL string, from uint64) string {
+	return fmt.Sprintf("%s.delta.%d.json", indexBaseName(indexURL), from)
+}
+
+// deltaBasePath returns where the canonical copy of the index of repo, which deltas are applied to, is kept
+func deltaBasePath(config *config, repo repository) string {
+	return cachePath(config, repo.URL, filepath.Base(indexBaseName(repo.URL))+".base.json")
+}
+
+func decodeGenericIndex(url string, bodyBytes []byte) (genericIndex, error) {
+	var index genericIndex
+	switch {
+	case strings.HasSuffix(url, ".cbor"):
+		decMode, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(genericEntry(nil))}.DecMode()
+		if err != nil {
+			return nil, errIndexDelta.Wrap(err)
+		}
+		if err := decMode.Unmarshal(bodyBytes, &index); err != nil {
+			return nil, errFileTypeInvalid.Wrap(err)
+		}
+	case strings.HasSuffix(url, ".json"):
+		if err := json.Unmarshal(bodyBytes, &index); err != nil {
+			return nil, errFileTypeInvalid.Wrap(err)
+		}
+	case strings.HasSuffix(url, ".yaml"):
+		if err := yaml.Unmarshal(bodyBytes, &index); err != nil {
+			return nil, errFileTypeInvalid.Wrap(err)
+		}
+	default:
+		return nil, errFileTypeInvalid.New("unsupported format for URL: %s", url)
+	}
+	return index, nil
+}
+
+// canonical encodes index as compact JSON with sorted keys, leaving out repositories without entries,
+// which is what the bsum of a delta is calculated over, whatever the format of the index is
+func (index genericIndex) canonical() ([]byte, string, error) {
+	nonEmpty := make(genericIndex, len(index))
+	for repoName, entries := range index {
+		if len(entries) > 0 {
+			nonEmpty[repoName] = entries
+		}
+	}
+	data, err := json.Marshal(nonEmpty)
+	if err != nil {
+		return nil, "", errIndexDelta.Wrap(err)
+	}
+	sum := blake3.Sum256(data)
+	return data, fmt.Sprintf("%x", sum[:]), nil
+}
+
+// apply removes, replaces and then adds the entries of delta. An entry that isn't where delta expects it
+// means that index is not the version delta was made for
+func (index genericIndex) apply(delta *indexDelta) error {
+	position := func(entries []genericEntry, key string) int {
+		for i, entry := range entries {
+			if entryKey(entry) == key {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for repoName, keys := range delta.Removed {
+		for _, key := range keys {
+			i := position(index[repoName], key)
+			if i == -1 {
+				return errIndexDelta.New("%s: can't remove %s, it is not in the index", repoName, key)
+			}
+			index[repoName] = append(index[repoName][:i], index[repoName][i+1:]...)
+		}
+	}
+
+	for repoName, entries := range delta.Changed {
+		for _, entry := range entries {
+			i := position(index[repoName], entryKey(entry))
+			if i == -1 {
+				return errIndexDelta.New("%s: can't change %s, it is not in the index", repoName, entryKey(entry))
+			}
+			index[repoName][i] = entry
+		}
+	}
+
+	for repoName, added := range delta.Added {
+		for _, a := range added {
+			if position(index[repoName], entryKey(a.Entry)) != -1 {
+				return errIndexDelta.New("%s: can't add %s, it is already in the index", repoName, entryKey(a.Entry))
+			}
+			i := 0
+			if a.After != "" {
+				if i = position(index[repoName], a.After); i == -1 {
+					return errIndexDelta.New("%s: can't add %s after %s, it is not in the index", repoName, entryKey(a.Entry), a.After)
+				}
+				i++
+			}
+			index[repoName] = append(index[repoName][:i], append([]genericEntry{a.Entry}, index[repoName][i:]...)...)
+		}
+	}
+
+	return nil
+}
+
+// fetchIndexDelta fetches the delta that updates the index of repo from version from, trying its fallbacks
+// in order. It returns nil if there is none, which breaks the chain
+func fetchIndexDelta(config *config, repo repository, from uint64) (*indexDelta, error) {
+	var lastErr error
+	for _, indexURL := range append([]string{repo.URL}, repo.FallbackURLs...) {
+		u := deltaURL(indexURL, from)
+
+		var body []byte
+		err := withRetries(context.Background(), config, u, func() error {
+			req, err := createHTTPRequest(context.Background(), "GET", u)
+			if err != nil {
+				return errIndexDelta.Wrap(err)
+			}
+			resp, err := httpClient(config, &repo).Do(req)
+			if err != nil {
+				return errIndexDelta.Wrap(transientRequestError(req.Context(), err))
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode == http.StatusNotFound {
+				body = nil
+				return nil
+			}
+			if err := checkStatus(resp, http.StatusOK); err != nil {
+				return errIndexDelta.Wrap(err)
+			}
+			body, err = io.ReadAll(resp.Body)
+			return errIndexDelta.Wrap(transientRequestError(req.Context(), err))
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if body == nil {
+			continue
+		}
+
+		if err := verifyIndexSignature(config, repo, u, body); err != nil {
+			return nil, err
+		}
+		var delta indexDelta
+		if err := json.Unmarshal(body, &delta); err != nil {
+			return nil, errIndexDelta.New("%s: %v", u, err)
+		}
+		return &delta, nil
+	}
+	// Not found anywhere, unless some mirror couldn't tell
+	return nil, lastErr
+}
+
+// updateIndexWithDeltas brings the canonical copy of the index of repo up to the version of ts, one delta at a
+// time. It reports false if there is no such copy, or if the chain of deltas is broken or leads to an index that
+// doesn't match its checksum, in which case the full index has to be downloaded instead
+func updateIndexWithDeltas(config *config, repo repository, ts *indexTimestamp) ([]byte, string, bool) {
+	basePath := deltaBasePath(config, repo)
+	meta := loadCacheMeta(basePath)
+	if meta.Version == 0 || meta.Version > ts.Version || meta.Source != repo.URL {
+		return nil, "", false
+	}
+	bodyBytes, err := os.ReadFile(basePath)
+	if err != nil {
+		return nil, "", false
+	}
+	if meta.Version == ts.Version {
+		return bodyBytes, basePath, true
+	}
+
+	giveUp := func(format string, a ...any) ([]byte, string, bool) {
+		if verbosityLevel >= extraVerbose {
+			fmt.Fprintf(os.Stderr, "Warning: "+format+", downloading the whole index of %s\n", append(a, repo.URL)...)
+		}
+		return nil, "", false
+	}
+
+	index, err := decodeGenericIndex(basePath, bodyBytes)
+	if err != nil {
+		return giveUp("%v", err)
+	}
+
+	var last *indexDelta
+	version := meta.Version
+	for applied := 0; version < ts.Version; applied++ {
+		if applied == maxDeltaChain {
+			return giveUp("more than %d deltas separate version %d from %d", maxDeltaChain, meta.Version, ts.Version)
+		}
+		delta, err := fetchIndexDelta(config, repo, version)
+		if err != nil {
+			return giveUp("%v", err)
+		}
+		if delta == nil {
+			return giveUp("there is no delta from version %d", version)
+		}
+		if delta.From != version || delta.To <= version {
+			return giveUp("the delta from version %d goes from %d to %d", version, delta.From, delta.To)
+		}
+		if err := index.apply(delta); err != nil {
+			return giveUp("%v", err)
+		}
+		version, last = delta.To, delta
+	}
+	if version != ts.Version {
+		return giveUp("the deltas lead to version %d instead of %d", version, ts.Version)
+	}
+
+	bodyBytes, sum, err := index.canonical()
+	if err != nil {
+		return giveUp("%v", err)
+	}
+	if sum != strings.ToLower(last.Bsum) {
+		return giveUp("the index updated with deltas does not match its checksum: expected %s, got %s", last.Bsum, sum)
+	}
+	if err := saveDeltaBase(basePath, repo, ts.Version, bodyBytes); err != nil {
+		return giveUp("%v", err)
+	}
+
+	if verbosityLevel >= extraVerbose {
+		fmt.Fprintf(os.Stderr, "Updated the index of %s from version %d to %d with deltas\n", repo.URL, meta.Version, version)
+	}
+	return bodyBytes, basePath, true
+}
+
+// recordDeltaBase keeps a canonical copy of the (decompressed) index of repo at version, that later deltas
+// can be applied to. It is only rewritten when the version changes
+func recordDeltaBase(config *config, repo repository, version uint64, url string, bodyBytes []byte) {
+	basePath := deltaBasePath(config, repo)
+	if meta := loadCacheMeta(basePath); meta.Version == version && meta.Source == repo.URL {
+		return
+	}
+
+	index, err := decodeGenericIndex(url, bodyBytes)
+	if err == nil {
+		if bodyBytes, _, err = index.canonical(); err == nil {
+			err = saveDeltaBase(basePath, repo, version, bodyBytes)
+		}
+	}
+	if err != nil && verbosityLevel >= extraVerbose {
+		fmt.Fprintf(os.Stderr, "Warning: could not keep the index of %s for delta updates: %v\n", repo.URL, err)
+	}
+}
+
+func saveDeltaBase(basePath string, repo repository, version uint64, bodyBytes []byte) error {
+	tempFile := basePath + ".tmp"
+	if err := os.WriteFile(tempFile, bodyBytes, 0644); err != nil {
+		return errCacheAccess.Wrap(err)
+	}
+	meta := cacheMeta{Source: repo.URL, Version: version, Fetched: time.Now()}
+	if err := meta.save(tempFile); err != nil {
+		os.Remove(tempFile)
+		return errCacheAccess.Wrap(err)
+	}
+	return errCacheAccess.Wrap(os.Rename(tempFile, basePath))
+}
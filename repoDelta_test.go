@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// The indexes and deltas of testdata/delta are written by the index generator, see its TestSaveDeltaFixtures
+
+func readDeltaFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "delta", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// deltaTestRepo is a repository whose index is at version 1001 in the cache, with its deltas served by handler
+func deltaTestRepo(t *testing.T, handler http.Handler) (*config, repository) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	cfg := &config{CacheDir: t.TempDir(), RetryAttempts: 1}
+	repo := repository{URL: srv.URL + "/index.json"}
+	recordDeltaBase(cfg, repo, 1001, repo.URL, readDeltaFixture(t, "index.1001.json"))
+	return cfg, repo
+}
+
+func TestUpdateIndexWithDeltas(t *testing.T) {
+	cfg, repo := deltaTestRepo(t, http.FileServer(http.Dir(filepath.Join("testdata", "delta"))))
+
+	got, _, ok := updateIndexWithDeltas(cfg, repo, &indexTimestamp{Version: 1003})
+	if !ok {
+		t.Fatal("the deltas were not applied")
+	}
+	full, err := decodeGenericIndex("index.json", readDeltaFixture(t, "index.1003.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := full.canonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("the index updated with deltas differs from the full one:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The result is the base the next deltas apply to
+	if meta := loadCacheMeta(deltaBasePath(cfg, repo)); meta.Version != 1003 {
+		t.Errorf("the delta base is at version %d, want 1003", meta.Version)
+	}
+	if again, _, ok := updateIndexWithDeltas(cfg, repo, &indexTimestamp{Version: 1003}); !ok || !bytes.Equal(again, want) {
+		t.Error("the updated index was not kept")
+	}
+}
+
+func TestUpdateIndexWithDeltasFallsBack(t *testing.T) {
+	fixtures := http.FileServer(http.Dir(filepath.Join("testdata", "delta")))
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"missing link", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, ".delta.1002.json") {
+				http.NotFound(w, r)
+				return
+			}
+			fixtures.ServeHTTP(w, r)
+		}},
+		{"link that skips a version", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, ".delta.1002.json") {
+				w.Write([]byte(`{"from":1001,"to":1003,"bsum":""}`))
+				return
+			}
+			fixtures.ServeHTTP(w, r)
+		}},
+		{"checksum mismatch", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, ".delta.1002.json") {
+				delta := bytes.Replace(readDeltaFixture(t, "index.delta.1002.json"), []byte(`"bsum":"`), []byte(`"bsum":"00`), 1)
+				w.Write(delta)
+				return
+			}
+			fixtures.ServeHTTP(w, r)
+		}},
+		{"delta for another base", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, ".delta.1001.json") {
+				w.Write([]byte(`{"from":1001,"to":1002,"bsum":"","removed":{"bincache":["missing#missing"]}}`))
+				return
+			}
+			fixtures.ServeHTTP(w, r)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, repo := deltaTestRepo(t, tt.handler)
+			if _, _, ok := updateIndexWithDeltas(cfg, repo, &indexTimestamp{Version: 1003}); ok {
+				t.Error("applied a broken chain of deltas, instead of downloading the whole index")
+			}
+			if meta := loadCacheMeta(deltaBasePath(cfg, repo)); meta.Version != 1001 {
+				t.Errorf("the delta base moved to version %d", meta.Version)
+			}
+		})
+	}
+}
+
+func TestUpdateIndexWithDeltasChainLimit(t *testing.T) {
+	var requests atomic.Int32
+	cfg, repo := deltaTestRepo(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var from uint64
+		fmt.Sscanf(filepath.Base(r.URL.Path), "index.delta.%d.json", &from)
+		fmt.Fprintf(w, `{"from":%d,"to":%d,"bsum":""}`, from, from+1)
+	}))
+
+	if _, _, ok := updateIndexWithDeltas(cfg, repo, &indexTimestamp{Version: 1001 + maxDeltaChain + 1}); ok {
+		t.Error("applied more than maxDeltaChain deltas")
+	}
+	if n := requests.Load(); n != maxDeltaChain {
+		t.Errorf("fetched %d deltas, want to give up after %d", n, maxDeltaChain)
+	}
+}
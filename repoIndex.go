@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// repoIndex holds the entries of every repository, in the order they were decoded, and finds them
+// by name, pkg_id, repository or provided binary without going through all of them
+type repoIndex struct {
+	mu          sync.Mutex
+	entries     []binaryEntry
+	cache       *indexCache // the entries that weren't decoded yet are read from, nil once they all are
+	decoded     []bool      // which entries were read from cache
+	preferences preferences // of the user, to pick among the variants of a package
+	byName      map[string][]int
+	byPkgID     map[string][]int
+	byRepo      map[string][]int
+	provides    map[string][]int
+}
+
+func makeRepoIndex(size int, prefs preferences) *repoIndex {
+	return &repoIndex{
+		preferences: prefs,
+		byName:      make(map[string][]int, size),
+		byPkgID:     make(map[string][]int, size),
+		byRepo:      make(map[string][]int),
+		provides:    make(map[string][]int),
+	}
+}
+
+func newRepoIndex(entries []binaryEntry, prefs preferences) *repoIndex {
+	idx := makeRepoIndex(len(entries), prefs)
+	idx.entries = entries
+	for i, entry := range entries {
+		idx.add(i, entry.Name, entry.PkgID, entry.Repository.Name, entry.ExtraBins)
+	}
+	return idx
+}
+
+// newCachedRepoIndex finds the entries of cache by their keys, and only decodes those that are asked for
+func newCachedRepoIndex(cache *indexCache, prefs preferences) *repoIndex {
+	idx := makeRepoIndex(cache.len(), prefs)
+	idx.entries = make([]binaryEntry, cache.len())
+	idx.decoded = make([]bool, cache.len())
+	idx.cache = cache
+	for i, key := range cache.keys {
+		idx.add(i, key.name, key.pkgID, cache.repos[key.repo].Name, key.extraBins)
+	}
+	return idx
+}
+
+// add makes the entry at pos findable by its keys
+func (idx *repoIndex) add(pos int, name, pkgID, repoName, extraBins string) {
+	idx.byName[name] = append(idx.byName[name], pos)
+	idx.byPkgID[pkgID] = append(idx.byPkgID[pkgID], pos)
+	idx.byRepo[repoName] = append(idx.byRepo[repoName], pos)
+	for _, bin := range strings.Split(extraBins, ",") {
+		if bin = strings.TrimSpace(bin); bin != "" {
+			idx.provides[bin] = append(idx.provides[bin], pos)
+		}
+	}
+}
+
+// decode reads the entry at pos from the cache, unless it already was. idx.mu must be held
+func (idx *repoIndex) decode(pos int) bool {
+	if idx.cache == nil || idx.decoded[pos] {
+		return true
+	}
+	entry, err := idx.cache.entry(pos)
+	if err != nil {
+		if verbosityLevel >= silentVerbosityWithErrors {
+			fmt.Fprintf(os.Stderr, "Warning: skipping an entry of the index cache: %v\n", err)
+		}
+		return false
+	}
+	idx.entries[pos], idx.decoded[pos] = entry, true
+	return true
+}
+
+// pick returns copies of the entries at positions, so that callers can modify them
+func (idx *repoIndex) pick(positions []int) []binaryEntry {
+	if len(positions) == 0 {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	entries := make([]binaryEntry, 0, len(positions))
+	for _, pos := range positions {
+		if idx.decode(pos) {
+			entries = append(entries, idx.entries[pos])
+		}
+	}
+	return entries
+}
+
+// all returns every entry, the caller must not modify them
+func (idx *repoIndex) all() []binaryEntry {
+	if idx == nil {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.cache != nil {
+		entries, err := idx.cache.entries()
+		if err != nil {
+			// Only the entries that can't be decoded are left out
+			var decoded []binaryEntry
+			for pos := range idx.entries {
+				if idx.decode(pos) {
+					decoded = append(decoded, idx.entries[pos])
+				}
+			}
+			return decoded
+		}
+		idx.entries, idx.cache, idx.decoded = entries, nil, nil
+	}
+	return idx.entries
+}
+
+func (idx *repoIndex) named(name string) []binaryEntry {
+	return idx.pick(idx.byName[name])
+}
+
+func (idx *repoIndex) withPkgID(pkgID string) []binaryEntry {
+	return idx.pick(idx.byPkgID[pkgID])
+}
+
+// inRepos returns the entries of the repositories called names, in the order they were decoded
+func (idx *repoIndex) inRepos(names ...string) []binaryEntry {
+	var positions []int
+	for _, name := range names {
+		positions = append(positions, idx.byRepo[name]...)
+	}
+	if len(names) > 1 {
+		slices.Sort(positions)
+		positions = slices.Compact(positions)
+	}
+	return idx.pick(positions)
+}
+
+// providing returns the entries whose packages provide bin, besides the binary they are named after
+func (idx *repoIndex) providing(bin string) []binaryEntry {
+	return idx.pick(idx.provides[bin])
+}
+
+// contains reports whether there is an entry called name, with the pkg_id pkgID
+func (idx *repoIndex) contains(name, pkgID string) bool {
+	for _, pos := range idx.byPkgID[pkgID] {
+		if slices.Contains(idx.byName[name], pos) {
+			return true
+		}
+	}
+	return false
+}
@@ -31,6 +31,26 @@ type binaryEntry struct {
 	Rank        uint16     `json:"rank,omitempty"        `
 	WebManifest string     `json:"web_manifest,omitempty"`
 	// specific to `dbin`'s internal needs:
-	binaryPath string      `json:"-"`
-	Repository  repository
+	binaryPath      string                 `json:"-"`
+	verifiedDigests []string               `json:"-"`
+	localBsum       string                 `json:"-"` // b3sum of the file as it was installed
+	blobDigest      string                 `json:"-"` // digest of the OCI blob being downloaded, as its descriptor states it
+	signature       func() ([]byte, error) `json:"-"` // fetches the detached signature of the file being downloaded, nil if there is none
+	slots           connectionSlots        `json:"-"` // for the connections the download opens besides its first one
+	Repository      repoRef
+}
+
+// repoRef names the repository an entry comes from, as its index calls it, and points to the configured
+// repository that serves it, which all the entries of its index share
+type repoRef struct {
+	Name string
+	repo *repository
+}
+
+// config returns the configured repository, an empty one for entries that don't come from an index
+func (r repoRef) config() *repository {
+	if r.repo == nil {
+		return &repository{}
+	}
+	return r.repo
 }
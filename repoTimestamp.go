@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errIndexRollback  = errs.Class("index rollback detected")
+	errIndexExpired   = errs.Class("index expired")
+	errIndexTimestamp = errs.Class("index timestamp error")
+	indexVersionsMu   sync.Mutex
+)
+
+// indexTimestamp is a small document published next to an index file (<index>.timestamp.json),
+// it lets dbin notice mirrors that serve an old index, or that stopped being updated
+type indexTimestamp struct {
+	Version uint64    `json:"version"`
+	Expires time.Time `json:"expires"`
+	Bsum    string    `json:"bsum,omitempty"` // b3sum of the (decompressed) index file
+}
+
+// timestampURL returns the URL of the timestamp document of the index at indexURL
+func timestampURL(indexURL string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(indexURL, ".zst"), ".gz") + ".timestamp.json"
+}
+
+func parseIndexTimestamp(data []byte) (*indexTimestamp, error) {
+	var ts indexTimestamp
+	if err := json.Unmarshal(data, &ts); err != nil {
+		return nil, errIndexTimestamp.Wrap(err)
+	}
+	if ts.Version == 0 {
+		return nil, errIndexTimestamp.New("timestamp document has no version")
+	}
+	return &ts, nil
+}
+
+// fetchIndexTimestamp returns the timestamp document of repo. A repository that never published one
+// is allowed to go without it, but once one was seen, it can't be taken away
+func fetchIndexTimestamp(config *config, repo repository, syncInterval time.Duration) (*indexTimestamp, error) {
+	validate := func(u string, body []byte) error {
+		if err := verifyIndexSignature(config, repo, u, body); err != nil {
+			return err
+		}
+		ts, err := parseIndexTimestamp(body)
+		if err != nil {
+			return err
+		}
+		return checkIndexVersion(config, repo, ts)
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if path, ok := strings.CutPrefix(timestampURL(repo.URL), "file://"); ok {
+		if data, err = os.ReadFile(path); err == nil {
+			err = validate("file://"+path, data)
+		}
+	} else {
+		var urls []string
+		for _, u := range append([]string{repo.URL}, repo.FallbackURLs...) {
+			urls = append(urls, timestampURL(u))
+		}
+		// Most repositories don't publish one, which is only worth a warning once they did
+		repo.optional = lastSeenIndexVersion(config, repo) == 0
+		data, err = accessCachedOrFetch(urls, "", config, &repo, syncInterval, validate)
+	}
+
+	if err != nil {
+		if lastSeenIndexVersion(config, repo) > 0 {
+			return nil, errIndexTimestamp.New("%s published a timestamp document before, but no valid one could be fetched now: %v", repo.URL, err)
+		}
+		if verbosityLevel >= extraVerbose {
+			fmt.Fprintf(os.Stderr, "Warning: %s has no timestamp document, its freshness can't be checked\n", repo.URL)
+		}
+		return nil, nil
+	}
+
+	ts, err := parseIndexTimestamp(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIndexVersion(config, repo, ts); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// checkIndexVersion refuses timestamp documents older than the newest one seen for repo
+func checkIndexVersion(config *config, repo repository, ts *indexTimestamp) error {
+	if seen := lastSeenIndexVersion(config, repo); ts.Version < seen {
+		return errIndexRollback.New("%s served index version %d, but version %d was already seen", repo.URL, ts.Version, seen)
+	}
+	return nil
+}
+
+// checkIndexFreshness binds the index to its timestamp document, enforces its expiry and records its version
+func checkIndexFreshness(config *config, repo repository, ts *indexTimestamp, bodyBytes []byte) error {
+	if err := checkIndexBsum(repo, ts, bodyBytes); err != nil {
+		return err
+	}
+
+	return checkIndexExpiry(config, repo, ts)
+}
+
+// checkIndexBsum makes sure that the index is the one its timestamp document describes, if it has a checksum
+func checkIndexBsum(repo repository, ts *indexTimestamp, bodyBytes []byte) error {
+	if ts.Bsum != "" {
+		sum := blake3.Sum256(bodyBytes)
+		if calculated := fmt.Sprintf("%x", sum[:]); calculated != strings.ToLower(ts.Bsum) {
+			return errIndexTimestamp.New("index of %s does not match its timestamp document: expected %s, got %s", repo.URL, ts.Bsum, calculated)
+		}
+	}
+	return nil
+}
+
+// checkIndexExpiry enforces the expiry of the index and records its version
+func checkIndexExpiry(config *config, repo repository, ts *indexTimestamp) error {
+	if !ts.Expires.IsZero() {
+		config.indexExpiry.observe(ts.Expires)
+	}
+	if !ts.Expires.IsZero() && time.Now().After(ts.Expires) {
+		if repo.FreshnessPolicy == policyStrict {
+			return errIndexExpired.New("index of %s (version %d) expired on %s", repo.URL, ts.Version, ts.Expires.Format(time.RFC3339))
+		}
+		if verbosityLevel >= silentVerbosityWithErrors {
+			fmt.Fprintf(os.Stderr, "Warning: index of %s (version %d) expired on %s\n", repo.URL, ts.Version, ts.Expires.Format(time.RFC3339))
+		}
+	}
+
+	return recordIndexVersion(config, repo, ts.Version)
+}
+
+func indexVersionsPath(config *config) string {
+	return filepath.Join(config.CacheDir, ".index_versions.json")
+}
+
+func readIndexVersions(config *config) map[string]uint64 {
+	versions := make(map[string]uint64)
+	if data, err := os.ReadFile(indexVersionsPath(config)); err == nil {
+		_ = json.Unmarshal(data, &versions)
+	}
+	return versions
+}
+
+func lastSeenIndexVersion(config *config, repo repository) uint64 {
+	indexVersionsMu.Lock()
+	defer indexVersionsMu.Unlock()
+	return readIndexVersions(config)[repo.URL]
+}
+
+func recordIndexVersion(config *config, repo repository, version uint64) error {
+	indexVersionsMu.Lock()
+	defer indexVersionsMu.Unlock()
+
+	versions := readIndexVersions(config)
+	if versions[repo.URL] >= version {
+		return nil
+	}
+	versions[repo.URL] = version
+
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return errIndexTimestamp.Wrap(err)
+	}
+	if err := os.MkdirAll(config.CacheDir, 0755); err != nil {
+		return errCacheAccess.Wrap(err)
+	}
+	tempFile := indexVersionsPath(config) + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return errCacheAccess.Wrap(err)
+	}
+	return os.Rename(tempFile, indexVersionsPath(config))
+}
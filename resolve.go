@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errResolveFailed = errs.Class("resolve failed")
+)
+
+// variantOrder mirrors the order the index generator sorts the variants of a package in, the most
+// compatible ones (truly static, baseline microarchitecture) first. Packages without a variant come last
+var variantOrder = []string{"musl", "ppkg", "glibc", "musl-v3", "glibc-v3", "musl-v4", "glibc-v4"}
+
+// variantOf returns the variant of a package, as named in its pkg_id, and its position in variantOrder
+func variantOf(pkgID string) (string, int) {
+	// The longest name that matches, so that "musl-v3" wins over "musl"
+	variant, position := "", len(variantOrder)
+	for i, v := range variantOrder {
+		if strings.Contains(pkgID, v) && len(v) > len(variant) {
+			variant, position = v, i
+		}
+	}
+	return variant, position
+}
+
+// resolutionStep is one of the criteria candidates are compared on, in order, until one of them differs
+type resolutionStep struct {
+	name     string
+	key      func(bin binaryEntry) int // lower wins
+	describe func(bin binaryEntry) string
+}
+
+// resolutionSteps returns the criteria candidates are compared on: the priority of their repository, how they
+// fare against prefs, if there are any, their rank, and last the order the index generator sorts variants in
+func resolutionSteps(prefs preferences) []resolutionStep {
+	steps := []resolutionStep{priorityStep}
+	if !prefs.empty() {
+		steps = append(steps, resolutionStep{
+			name:     "preferences",
+			key:      func(bin binaryEntry) int { return prefs.rank(bin.PkgID) },
+			describe: func(bin binaryEntry) string { return prefs.describe(bin.PkgID) },
+		})
+	}
+	return append(steps, rankStep, variantStep)
+}
+
+var (
+	priorityStep = resolutionStep{
+		name: "repository priority",
+		key:  func(bin binaryEntry) int { return -bin.Repository.config().Priority },
+		describe: func(bin binaryEntry) string {
+			return fmt.Sprintf("priority %d", bin.Repository.config().Priority)
+		},
+	}
+	rankStep = resolutionStep{
+		name: "rank",
+		key: func(bin binaryEntry) int {
+			if bin.Rank == 0 {
+				return math.MaxInt
+			}
+			return int(bin.Rank)
+		},
+		describe: func(bin binaryEntry) string {
+			return ternary(bin.Rank == 0, "unranked", fmt.Sprintf("rank %d", bin.Rank))
+		},
+	}
+	variantStep = resolutionStep{
+		name: "variant preference",
+		key: func(bin binaryEntry) int {
+			_, position := variantOf(bin.PkgID)
+			return position
+		},
+		describe: func(bin binaryEntry) string {
+			variant, _ := variantOf(bin.PkgID)
+			return ternary(variant == "", "no variant", variant+" variant")
+		},
+	}
+)
+
+// compareCandidates orders a before b if it wins on the first criterion they differ on, which it returns.
+// Candidates that don't differ at all keep the order of the index
+func compareCandidates(steps []resolutionStep, a, b binaryEntry) (int, *resolutionStep) {
+	for i := range steps {
+		step := &steps[i]
+		if ka, kb := step.key(a), step.key(b); ka != kb {
+			return ternary(ka < kb, -1, 1), step
+		}
+	}
+	return 0, nil
+}
+
+// sortCandidates puts the candidate that should be picked for a package first
+func sortCandidates(candidates []binaryEntry, prefs preferences) {
+	steps := resolutionSteps(prefs)
+	slices.SortStableFunc(candidates, func(a, b binaryEntry) int {
+		cmp, _ := compareCandidates(steps, a, b)
+		return cmp
+	})
+}
+
+func describeCandidate(steps []resolutionStep, bin binaryEntry) string {
+	var criteria []string
+	for _, step := range steps {
+		criteria = append(criteria, step.describe(bin))
+	}
+	return strings.Join(criteria, ", ")
+}
+
+func resolveCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "resolve",
+		Usage:     "Explain which candidate is picked for a binary, and why",
+		ArgsUsage: "<binary>",
+		Action: func(_ context.Context, c *cli.Command) error {
+			if c.Args().Len() != 1 {
+				return errResolveFailed.New("expected a single binary, got %d", c.Args().Len())
+			}
+			config, err := loadConfig()
+			if err != nil {
+				return errResolveFailed.Wrap(err)
+			}
+			uRepoIndex, err := fetchRepoIndex(config)
+			if err != nil {
+				return errResolveFailed.Wrap(err)
+			}
+			return explainResolution(config, stringToBinaryEntry(c.Args().First()), uRepoIndex)
+		},
+	}
+}
+
+// explainResolution lists the candidates for bEntry in the order they are picked in, telling what
+// puts each of them behind the previous one
+func explainResolution(config *config, bEntry binaryEntry, uRepoIndex *repoIndex) error {
+	// Like findURL, an installed binary keeps being taken from the package it was installed from
+	if resolved, installed := withInstalledMetadata(config, bEntry); installed {
+		bEntry = resolved
+		fmt.Printf("%s is installed, only %s is considered\n", bEntry.Name, parseBinaryEntry(bEntry, true))
+	}
+
+	disabled := disabledRepositories(config)
+	candidates := findMatchingBins(bEntry, uRepoIndex)
+	if len(candidates) == 0 {
+		return errResolveFailed.New("no candidates for [%s]%s%s%s", parseBinaryEntry(bEntry, false), providedBy(bEntry.Name, uRepoIndex), unsupportedMatches(bEntry, uRepoIndex),
+			ternary(len(disabled) > 0, " (disabled repositories: "+strings.Join(disabled, ", ")+")", ""))
+	}
+
+	steps := resolutionSteps(uRepoIndex.preferences)
+	for i, bin := range candidates {
+		fmt.Printf("%d. %s (%s)\n", i+1, parseBinaryEntry(bin, true), describeCandidate(steps, bin))
+		if i == 0 {
+			continue
+		}
+		if _, step := compareCandidates(steps, candidates[i-1], bin); step != nil {
+			fmt.Printf("   after %d: %s, %s over %s\n", i, step.name, step.describe(candidates[i-1]), step.describe(bin))
+		} else {
+			fmt.Printf("   after %d: it comes later in the index\n", i)
+		}
+	}
+
+	_, unsupported := splitBySupport(matchBins(bEntry, uRepoIndex))
+	for _, bin := range unsupported {
+		fmt.Printf("Left out %s, it is %s\n", parseBinaryEntry(bin, true), unsupportedVariant(bin.PkgID))
+	}
+	if len(disabled) > 0 {
+		fmt.Printf("Repositories left out, as they are disabled: %s\n", strings.Join(disabled, ", "))
+	}
+	fmt.Printf("%s resolves to %s\n", parseBinaryEntry(bEntry, true), parseBinaryEntry(candidates[0], true))
+	return nil
+}
+
+// disabledRepositories returns the URLs of the repositories that are disabled
+func disabledRepositories(config *config) []string {
+	var disabled []string
+	for _, repo := range config.Repositories {
+		if !repo.enabled() {
+			disabled = append(disabled, repo.URL)
+		}
+	}
+	return disabled
+}
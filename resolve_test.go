@@ -0,0 +1,78 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSortCandidates(t *testing.T) {
+	preferred := &repository{Priority: 1}
+	entry := func(pkgID string, rank uint16, repo *repository) binaryEntry {
+		return binaryEntry{PkgID: pkgID, Rank: rank, Repository: repoRef{repo: repo}}
+	}
+
+	tests := []struct {
+		name       string
+		prefs      preferences
+		candidates []binaryEntry
+		want       []string
+	}{
+		{
+			"rank, then variant",
+			preferences{},
+			[]binaryEntry{entry("app#glibc", 2, nil), entry("app#musl-v3", 1, nil), entry("app#musl", 1, nil)},
+			[]string{"app#musl", "app#musl-v3", "app#glibc"},
+		},
+		{
+			"preferences before rank",
+			preferences{Prefer: []string{"*glibc*"}},
+			[]binaryEntry{entry("app#musl", 1, nil), entry("app#glibc", 2, nil)},
+			[]string{"app#glibc", "app#musl"},
+		},
+		{
+			"avoided despite its rank",
+			preferences{Avoid: []string{"*upx*"}},
+			[]binaryEntry{entry("app#musl.upx", 1, nil), entry("app#musl", 0, nil)},
+			[]string{"app#musl", "app#musl.upx"},
+		},
+		{
+			"repository priority before preferences",
+			preferences{Prefer: []string{"*glibc*"}},
+			[]binaryEntry{entry("app#glibc", 1, nil), entry("app#musl", 2, preferred)},
+			[]string{"app#musl", "app#glibc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sortCandidates(tt.candidates, tt.prefs)
+			var got []string
+			for _, bin := range tt.candidates {
+				got = append(got, bin.PkgID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("sorted to %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVariantOf(t *testing.T) {
+	tests := []struct {
+		pkgID    string
+		variant  string
+		position int
+	}{
+		{"github.com.sharkdp.bat#musl", "musl", 0},
+		{"github.com.sharkdp.bat#glibc", "glibc", 2},
+		{"github.com.sharkdp.bat#musl-v3", "musl-v3", 3},
+		{"github.com.sharkdp.bat#glibc-v4.upx", "glibc-v4", 6},
+		{"busybox#ppkg", "ppkg", 1},
+		{"github.com.sharkdp.bat", "", len(variantOrder)},
+	}
+	for _, tt := range tests {
+		if variant, position := variantOf(tt.pkgID); variant != tt.variant || position != tt.position {
+			t.Errorf("variantOf(%q) = %q, %d, want %q, %d", tt.pkgID, variant, position, tt.variant, tt.position)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/xattr"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errContentRange = errs.Class("unexpected Content-Range")
+)
+
+const (
+	resumeXAttr = "user.dbin.resume"
+	// resumeCheckpointInterval is how often (in bytes) the progress of a download is recorded
+	resumeCheckpointInterval = 512 * 1024
+)
+
+// resumeMeta is kept in the xattrs of a .tmp file, so that an interrupted download
+// can be picked up where it was left, as long as it is the same file that is downloaded
+type resumeMeta struct {
+	Source       string `json:"source"` // URL, or digest of the OCI blob
+	Offset       int64  `json:"offset"`
+	Digest       string `json:"digest"` // b3sum of the first Offset bytes
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// validator returns what the If-Range header may hold, weak ETags can't be used there
+func (m *resumeMeta) validator() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+func (m *resumeMeta) save(tempFile string) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return xattr.Set(tempFile, resumeXAttr, raw)
+}
+
+// loadResumeMeta returns where the download of source into tempFile can be resumed from. A .tmp file
+// left by the download of something else, or whose content doesn't match its checkpoint, is discarded
+func loadResumeMeta(tempFile, source string) resumeMeta {
+	fresh := resumeMeta{Source: source}
+
+	raw, err := xattr.Get(tempFile, resumeXAttr)
+	if err != nil {
+		return fresh
+	}
+	var meta resumeMeta
+	if err := json.Unmarshal(raw, &meta); err != nil || meta.Source != source || meta.Offset <= 0 {
+		os.Remove(tempFile)
+		return fresh
+	}
+
+	file, err := os.Open(tempFile)
+	if err != nil {
+		return fresh
+	}
+	defer file.Close()
+
+	hash := blake3.New()
+	if _, err := io.CopyN(hash, file, meta.Offset); err != nil || hex.EncodeToString(hash.Sum(nil)) != meta.Digest {
+		if verbosityLevel >= extraVerbose {
+			fmt.Fprintf(os.Stderr, "Warning: %s doesn't match its checkpoint, starting over\n", tempFile)
+		}
+		os.Remove(tempFile)
+		return fresh
+	}
+
+	return meta
+}
+
+// setRangeHeaders asks for the part of the file that follows meta.Offset. If validated, the range is
+// only honoured if the file still is the one that was being downloaded (If-Range)
+func setRangeHeaders(req *http.Request, meta *resumeMeta, validated bool) {
+	if meta == nil || meta.Offset <= 0 {
+		return
+	}
+	if validated {
+		validator := meta.validator()
+		if validator == "" {
+			// Without a validator there is no telling whether the file changed, so it is downloaded again
+			meta.Offset = 0
+			return
+		}
+		req.Header.Set("If-Range", validator)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", meta.Offset))
+}
+
+// checkResumed makes sure that a 206 continues the file right where meta.Offset says
+func checkResumed(resp *http.Response, meta *resumeMeta) error {
+	if etag := resp.Header.Get("ETag"); etag != "" && meta.ETag != "" && etag != meta.ETag {
+		return errContentRange.New("ETag changed from %s to %s", meta.ETag, etag)
+	}
+
+	// Content-Range: bytes <first>-<last>/<size or *>
+	contentRange := resp.Header.Get("Content-Range")
+	rangeSpec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return errContentRange.New("%q", contentRange)
+	}
+	first, _, _ := strings.Cut(rangeSpec, "-")
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return errContentRange.New("%q", contentRange)
+	}
+	if start != meta.Offset {
+		return errContentRange.New("asked for the bytes from %d on, got %q", meta.Offset, contentRange)
+	}
+	return nil
+}
+
+// startOver resets meta after a response that doesn't continue the partial download: a 200 carries
+// the whole file, so its validators replace the old ones
+func startOver(resp *http.Response, meta *resumeMeta) {
+	*meta = resumeMeta{Source: meta.Source}
+	if resp != nil && resp.StatusCode == http.StatusOK {
+		meta.ETag, meta.LastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zeebo/blake3"
+)
+
+// testPayload returns n bytes that pass validateFileType, and their b3sum
+func testPayload(n int) ([]byte, string) {
+	payload := make([]byte, n)
+	copy(payload, "\x7fELF")
+	for i := 4; i < n; i++ {
+		payload[i] = byte(i * 7)
+	}
+	return payload, digestOf(payload)
+}
+
+// writeCheckpoint leaves the first offset bytes of payload in tempFile, as an interrupted download would
+func writeCheckpoint(t *testing.T, tempFile string, payload []byte, meta resumeMeta) {
+	t.Helper()
+	if err := os.WriteFile(tempFile, payload[:meta.Offset], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := meta.save(tempFile); err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			t.Skipf("xattrs are not supported here: %v", err)
+		}
+		t.Fatal(err)
+	}
+}
+
+func digestOf(data []byte) string {
+	sum := blake3.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// rangeLog records the Range headers a test server received
+type rangeLog struct {
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (l *rangeLog) record(r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ranges = append(l.ranges, r.Header.Get("Range"))
+}
+
+func (l *rangeLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.ranges...)
+}
+
+// download runs a single download attempt of url into destination, as fetchBinaryFromURLToDest does
+func download(t *testing.T, url, destination string, bEntry *binaryEntry) error {
+	t.Helper()
+	meta := loadResumeMeta(destination+".tmp", url)
+	resp, err := createDownloadRequest(context.Background(), http.DefaultClient, url, &meta)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return downloadWithProgress(context.Background(), nil, resp, destination, bEntry, &config{}, meta)
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	payload, bsum := testPayload(3 * resumeCheckpointInterval)
+	const etag = `"v1"`
+	var log rangeLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "bin", time.Time{}, bytes.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	destination := filepath.Join(t.TempDir(), "bin")
+	offset := int64(resumeCheckpointInterval)
+	writeCheckpoint(t, destination+".tmp", payload, resumeMeta{Source: srv.URL, Offset: offset, Digest: digestOf(payload[:offset]), ETag: etag})
+
+	if err := download(t, srv.URL, destination, &binaryEntry{Name: "bin", Bsum: bsum}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := log.get(); len(got) != 1 || got[0] != fmt.Sprintf("bytes=%d-", offset) {
+		t.Errorf("requested ranges %q, want a single one from %d on", got, offset)
+	}
+	got, err := os.ReadFile(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("resumed file differs from the original (%d bytes, want %d)", len(got), len(payload))
+	}
+	if _, err := os.Stat(destination + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the .tmp file was left behind: %v", err)
+	}
+}
+
+func TestResumeStartsOver(t *testing.T) {
+	payload, bsum := testPayload(2 * resumeCheckpointInterval)
+	offset := int64(resumeCheckpointInterval)
+
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter, r *http.Request)
+	}{
+		{
+			// The server ignores Range, and sends the whole file
+			name: "200 instead of 206",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				w.WriteHeader(http.StatusOK)
+				w.Write(payload)
+			},
+		},
+		{
+			// The server sends a range other than the one asked for
+			name: "Content-Range mismatch",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				if r.Header.Get("Range") == "" {
+					w.Write(payload)
+					return
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(payload)-1, len(payload)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(payload)
+			},
+		},
+		{
+			// The file changed since the checkpoint, so If-Range doesn't hold and the server sends all of it
+			name: "ETag changed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v2"`)
+				http.ServeContent(w, r, "bin", time.Time{}, bytes.NewReader(payload))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log rangeLog
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				log.record(r)
+				tt.handler(w, r)
+			}))
+			defer srv.Close()
+
+			destination := filepath.Join(t.TempDir(), "bin")
+			// The partial file holds garbage, which must not end up in front of the new download
+			stale := bytes.Repeat([]byte{0xff}, int(offset))
+			writeCheckpoint(t, destination+".tmp", stale, resumeMeta{Source: srv.URL, Offset: offset, Digest: digestOf(stale), ETag: `"v1"`})
+
+			if err := download(t, srv.URL, destination, &binaryEntry{Name: "bin", Bsum: bsum}); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := log.get(); len(got) == 0 || got[0] == "" {
+				t.Errorf("requested ranges %q, want the first request to resume", got)
+			}
+			got, err := os.ReadFile(destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("downloaded file differs from the original (%d bytes, want %d)", len(got), len(payload))
+			}
+		})
+	}
+}
+
+func TestLoadResumeMetaDiscardsBadCheckpoints(t *testing.T) {
+	payload, _ := testPayload(resumeCheckpointInterval)
+	offset := int64(len(payload))
+	const source = "https://example.com/bin"
+
+	tests := []struct {
+		name string
+		meta resumeMeta
+	}{
+		{"digest mismatch", resumeMeta{Source: source, Offset: offset, Digest: digestOf([]byte("something else"))}},
+		{"other source", resumeMeta{Source: source + ".old", Offset: offset, Digest: digestOf(payload)}},
+		{"offset past the end", resumeMeta{Source: source, Offset: offset + 1, Digest: digestOf(payload)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempFile := filepath.Join(t.TempDir(), "bin.tmp")
+			writeCheckpoint(t, tempFile, payload, resumeMeta{Source: source, Offset: offset, Digest: digestOf(payload)})
+			if err := tt.meta.save(tempFile); err != nil {
+				t.Fatal(err)
+			}
+
+			if meta := loadResumeMeta(tempFile, source); meta != (resumeMeta{Source: source}) {
+				t.Errorf("loadResumeMeta = %+v, want a fresh start", meta)
+			}
+			if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+				t.Errorf("the .tmp file was kept: %v", err)
+			}
+		})
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		tempFile := filepath.Join(t.TempDir(), "bin.tmp")
+		want := resumeMeta{Source: source, Offset: offset, Digest: digestOf(payload), ETag: `"v1"`}
+		writeCheckpoint(t, tempFile, payload, want)
+		if meta := loadResumeMeta(tempFile, source); meta != want {
+			t.Errorf("loadResumeMeta = %+v, want %+v", meta, want)
+		}
+	})
+}
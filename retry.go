@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	errTransient = errs.Class("transient error")
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+	// Servers that ask for longer than this are not waited for
+	retryMaxAfter = 2 * time.Minute
+)
+
+// statusError is returned for HTTP responses with an unexpected status code
+type statusError struct {
+	url        string
+	code       int
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	if e.retryAfter > 0 {
+		return fmt.Sprintf("[%d] %s (retry after %s)", e.code, e.url, e.retryAfter)
+	}
+	return fmt.Sprintf("[%d] %s", e.code, e.url)
+}
+
+// retryableStatus tells whether a request that got this status code is worth trying again
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses the Retry-After header of resp, which holds either a number of seconds or an HTTP date
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// checkStatus returns nil if resp has one of the wanted status codes. Otherwise the error
+// is transient if the status code is worth retrying (see retryableStatus)
+func checkStatus(resp *http.Response, wanted ...int) error {
+	for _, code := range wanted {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	err := &statusError{url: resp.Request.URL.String(), code: resp.StatusCode, retryAfter: retryAfter(resp)}
+	if retryableStatus(resp.StatusCode) {
+		return errTransient.Wrap(err)
+	}
+	return err
+}
+
+// transientRequestError classifies the error of a failed request, only a canceled context is not worth retrying
+func transientRequestError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	return errTransient.Wrap(err)
+}
+
+// retryDelay is an exponential backoff with full jitter, so that the downloads that failed against the
+// same host don't come back to it all at once. The delay asked for by the server (Retry-After) is used instead, if any
+func retryDelay(attempt int, err error) time.Duration {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.retryAfter > 0 {
+		return statusErr.retryAfter
+	}
+	backoff := retryBaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > retryMaxDelay {
+		backoff = retryMaxDelay
+	}
+	return time.Duration(rand.Int64N(int64(backoff))) + time.Millisecond
+}
+
+// withRetries calls fn until it succeeds, fails with an error that is not transient, or cfg.RetryAttempts are spent
+func withRetries(ctx context.Context, cfg *config, what string, fn func() error) error {
+	attempts := max(cfg.RetryAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !errTransient.Has(err) || attempt >= attempts {
+			return err
+		}
+
+		delay := retryDelay(attempt, err)
+		if delay > retryMaxAfter {
+			return err
+		}
+		if verbosityLevel >= extraVerbose {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %v. Retrying in %s (%d/%d)\n", what, err, delay.Round(time.Millisecond), attempt+1, attempts)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
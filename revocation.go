@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errRevoked        = errs.Class("revoked binary")
+	errRevocationList = errs.Class("revocation list error")
+)
+
+// revocation marks a build (by its digests) or a whole package (by its pkg_id) as known-bad
+type revocation struct {
+	Bsum   string `json:"bsum,omitempty"`
+	Shasum string `json:"shasum,omitempty"`
+	PkgID  string `json:"pkg_id,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// revocationList is the document found at the revocationURL of a repository, it is signed like the index
+type revocationList struct {
+	Revoked []revocation `json:"revoked"`
+}
+
+func (r *revocation) matchesDigests(digests map[string]string) bool {
+	return (r.Bsum != "" && strings.EqualFold(r.Bsum, digests[digestB3sum])) ||
+		(r.Shasum != "" && strings.EqualFold(strings.TrimPrefix(r.Shasum, "sha256:"), digests[digestSHA256]))
+}
+
+func (r *revocation) String() string {
+	reason := ternary(r.Reason != "", r.Reason, "no reason given")
+	switch {
+	case r.PkgID != "":
+		return fmt.Sprintf("pkg_id %s was revoked: %s", r.PkgID, reason)
+	case r.Bsum != "":
+		return fmt.Sprintf("b3sum %s was revoked: %s", r.Bsum, reason)
+	default:
+		return fmt.Sprintf("sha256 %s was revoked: %s", r.Shasum, reason)
+	}
+}
+
+// revocationOf returns the revocation that applies to pkgID or to any of digests, if any
+func (l *revocationList) revocationOf(pkgID string, digests map[string]string) *revocation {
+	if l == nil {
+		return nil
+	}
+	for i := range l.Revoked {
+		r := &l.Revoked[i]
+		if (r.PkgID != "" && r.PkgID == pkgID) || r.matchesDigests(digests) {
+			return r
+		}
+	}
+	return nil
+}
+
+func parseRevocationList(data []byte) (*revocationList, error) {
+	var list revocationList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, errRevocationList.Wrap(err)
+	}
+	return &list, nil
+}
+
+// revocationURL returns where the revocation list of repo is published, next to its index unless the repo says otherwise
+func revocationURL(repo repository) string {
+	if repo.RevocationURL != "" {
+		return repo.RevocationURL
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(repo.URL, ".zst"), ".gz") + ".revocations"
+}
+
+// fetchRevocationList returns the revocation list of repo, or nil if it doesn't have one. Only the list that
+// the repo points to has to exist, the one next to its index is optional
+func fetchRevocationList(config *config, repo repository) (*revocationList, error) {
+	url := revocationURL(repo)
+	optional := repo.RevocationURL == ""
+
+	validate := func(u string, body []byte) error {
+		if err := verifyIndexSignature(config, repo, u, body); err != nil {
+			return err
+		}
+		_, err := parseRevocationList(body)
+		return err
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if path, ok := strings.CutPrefix(url, "file://"); ok {
+		if data, err = os.ReadFile(path); err == nil {
+			err = validate(url, data)
+		} else if optional && os.IsNotExist(err) {
+			return nil, nil
+		}
+	} else {
+		repo.optional = optional
+		data, err = accessCachedOrFetch([]string{url}, "", config, &repo, repo.SyncInterval, validate)
+		// Whether a repository that is only read from the cache publishes a list can't be told
+		if optional && (errFileNotFound.Has(err) || (err != nil && repo.cachedOnly)) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, errRevocationList.New("%s: %v", url, err)
+	}
+
+	return parseRevocationList(data)
+}
+
+// fetchRevocationLists merges the revocation lists of every repository
+func fetchRevocationLists(config *config) (*revocationList, error) {
+	merged := &revocationList{}
+	seen := make(map[string]bool)
+	for _, repo := range config.Repositories {
+		if seen[revocationURL(repo)] {
+			continue
+		}
+		seen[revocationURL(repo)] = true
+
+		list, err := fetchRevocationList(config, repo)
+		if err != nil {
+			return nil, err
+		}
+		if list != nil {
+			merged.Revoked = append(merged.Revoked, list.Revoked...)
+		}
+	}
+	return merged, nil
+}
+
+// checkRevoked refuses bEntry if its repository revoked its pkg_id or the digests it declares
+func checkRevoked(bEntry *binaryEntry) error {
+	if r := bEntry.Repository.config().revocations.revocationOf(bEntry.PkgID, expectedDigests(bEntry)); r != nil {
+		return errRevoked.New("%s: %s", parseBinaryEntry(*bEntry, false), r)
+	}
+	return nil
+}
+
+// revocationOfInstalled checks the binary at binaryPath, by the pkg_id it was installed as and by its actual
+// b3sum and sha256, as downloads are checked
+func revocationOfInstalled(list *revocationList, binaryPath string) (*revocation, error) {
+	if list == nil || len(list.Revoked) == 0 {
+		return nil, nil
+	}
+	digests, err := fileDigests(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	return list.revocationOf(bEntryOfinstalledBinary(binaryPath).PkgID, digests), nil
+}
@@ -58,6 +58,9 @@ func runFromCache(config *config, bEntry binaryEntry, args []string, transparent
 
 	cachedFile, err := isCached(config, bEntry)
 	if err == nil {
+		if err := checkCachedRevoked(config, cachedFile); err != nil {
+			return errRunFailed.Wrap(err)
+		}
 		if verbosityLevel >= normalVerbosity {
 			fmt.Printf("Running '%s' from cache...\n", parseBinaryEntry(bEntry, true))
 		}
@@ -111,6 +114,26 @@ func isCached(config *config, bEntry binaryEntry) (string, error) {
 	return "", errRunFailed.New("binary '%s' not found in cache or does not match the requested version", bEntry.Name)
 }
 
+// checkCachedRevoked refuses to run a cached binary that was revoked after it was fetched
+func checkCachedRevoked(config *config, cachedFile string) error {
+	revoked, err := fetchRevocationLists(config)
+	if err != nil {
+		if verbosityLevel >= silentVerbosityWithErrors {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		return nil
+	}
+	r, err := revocationOfInstalled(revoked, cachedFile)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		os.Remove(cachedFile)
+		return errRevoked.New("%s: %s", filepath.Base(cachedFile), r)
+	}
+	return nil
+}
+
 func runBinary(binaryPath string, args []string, env []string) error {
 	cmd := exec.Command(binaryPath, args...)
 	if env == nil {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// downloadScheduler starts queued downloads as soon as both a global slot and a slot for their host
+// are free, so that a busy (or rate-limiting) host doesn't hold back the downloads from the other ones
+type downloadScheduler struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	running    int
+	perHost    map[string]int
+	maxTotal   int
+	maxPerHost int
+}
+
+func newDownloadScheduler(cfg *config) *downloadScheduler {
+	s := &downloadScheduler{
+		perHost:    make(map[string]int),
+		maxTotal:   cfg.MaxParallelDownloads,
+		maxPerHost: cfg.MaxDownloadsPerHost,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// downloadHost returns the host a download URL (plain or oci://) will connect to
+func downloadHost(downloadURL string) string {
+	if ref, ok := strings.CutPrefix(downloadURL, "oci://"); ok {
+		registry, _, _ := strings.Cut(ref, "/")
+		return registry
+	}
+	if u, err := url.Parse(downloadURL); err == nil {
+		return u.Host
+	}
+	return downloadURL
+}
+
+func (s *downloadScheduler) fits(host string) bool {
+	return (s.maxTotal <= 0 || s.running < s.maxTotal) && (s.maxPerHost <= 0 || s.perHost[host] < s.maxPerHost)
+}
+
+// tryAcquire takes a slot for another connection to host, only if one is free right away. Downloads that
+// already hold a slot must not wait for more, or they could end up waiting for each other
+func (s *downloadScheduler) tryAcquire(host string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.fits(host) {
+		return false
+	}
+	s.running++
+	s.perHost[host]++
+	return true
+}
+
+func (s *downloadScheduler) release(host string) {
+	s.mu.Lock()
+	s.running--
+	s.perHost[host]--
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// connectionSlots lets a running download open more connections to its host (e.g: for its segments),
+// within the limits of the scheduler that started it. Without a scheduler, there are no limits
+type connectionSlots struct {
+	scheduler *downloadScheduler
+	host      string
+}
+
+func (c connectionSlots) tryAcquire() bool {
+	return c.scheduler == nil || c.scheduler.tryAcquire(c.host)
+}
+
+func (c connectionSlots) release() {
+	if c.scheduler != nil {
+		c.scheduler.release(c.host)
+	}
+}
+
+// run calls start for every bEntry, in order, skipping ahead of the ones whose host is busy. It returns once
+// every download was started, and start must call done when its download is over. The bEntries get the
+// connectionSlots of their host, to open more connections than the one they were started with
+func (s *downloadScheduler) run(bEntries []binaryEntry, start func(bEntry binaryEntry, done func())) {
+	pending := make([]binaryEntry, len(bEntries))
+	copy(pending, bEntries)
+
+	for len(pending) > 0 {
+		s.mu.Lock()
+		next := -1
+		for next == -1 {
+			for i := range pending {
+				if s.fits(downloadHost(pending[i].DownloadURL)) {
+					next = i
+					break
+				}
+			}
+			if next == -1 {
+				s.cond.Wait()
+			}
+		}
+
+		bEntry := pending[next]
+		pending = append(pending[:next], pending[next+1:]...)
+		host := downloadHost(bEntry.DownloadURL)
+		s.running++
+		s.perHost[host]++
+		s.mu.Unlock()
+
+		bEntry.slots = connectionSlots{scheduler: s, host: host}
+		var once sync.Once
+		start(bEntry, func() {
+			once.Do(func() { s.release(host) })
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -45,25 +46,23 @@ func searchCommand() *cli.Command {
 			}
 
 			// Apply repository filter if specified
+			bEntries := uRepoIndex.all()
 			if repoNames := c.String("repo"); repoNames != "" {
-				repoSet := make(map[string]struct{})
+				var repos []string
 				for _, repo := range strings.Split(repoNames, ",") {
-					repoSet[strings.TrimSpace(repo)] = struct{}{}
+					repos = append(repos, strings.TrimSpace(repo))
 				}
-				filterBEntries(&uRepoIndex, func(entry binaryEntry) bool {
-					_, ok := repoSet[entry.Repository.Name]
-					return ok
-				})
+				bEntries = uRepoIndex.inRepos(repos...)
 			}
 
-			return fSearch(config, c.Args().Slice(), uRepoIndex)
+			return fSearch(config, c.Args().Slice(), bEntries)
 		},
 	}
 }
 
-func fSearch(config *config, searchTerms []string, uRepoIndex []binaryEntry) error {
+func fSearch(config *config, searchTerms []string, bEntries []binaryEntry) error {
 	var results []binaryEntry
-	for _, bin := range uRepoIndex {
+	for _, bin := range bEntries {
 		name, pkgID, version, description, rank, repo := bin.Name, bin.PkgID, bin.Version, bin.Description, bin.Rank, bin.Repository
 		if name == "" || description == "" {
 			continue
@@ -94,11 +93,12 @@ func fSearch(config *config, searchTerms []string, uRepoIndex []binaryEntry) err
 		return errSearchFailed.New("too many matching binaries (+%d. [Use --limit or -l before your query]) found for '%s'", len(results), strings.Join(searchTerms, " "))
 	}
 	disableTruncation := config.DisableTruncation
+	installed, cached := pkgIDsIn(config.InstallDir), pkgIDsIn(config.CacheDir)
 	for _, result := range results {
 		prefix := "[-]"
-		if bEntryOfinstalledBinary(filepath.Join(config.InstallDir, filepath.Base(result.Name))).PkgID == result.PkgID {
+		if pkgID := installed(result.Name); pkgID != "" && pkgID == result.PkgID {
 			prefix = "[i]"
-		} else if _, err := isCached(config, result); err == nil {
+		} else if pkgID := cached(result.Name); pkgID != "" && (pkgID == result.PkgID || result.PkgID == "") {
 			prefix = "[c]"
 		}
 		truncatePrintf(disableTruncation, "%s %s - %s\n",
@@ -106,3 +106,27 @@ func fSearch(config *config, searchTerms []string, uRepoIndex []binaryEntry) err
 	}
 	return nil
 }
+
+// pkgIDsIn returns a function that tells the pkg_id of the binary called name in dir. Only the binaries
+// that are in dir have their metadata read, each of them once
+func pkgIDsIn(dir string) func(name string) string {
+	present := make(map[string]bool)
+	if files, err := os.ReadDir(dir); err == nil {
+		for _, file := range files {
+			present[file.Name()] = true
+		}
+	}
+	pkgIDs := make(map[string]string)
+	return func(name string) string {
+		name = filepath.Base(name)
+		if !present[name] {
+			return ""
+		}
+		pkgID, ok := pkgIDs[name]
+		if !ok {
+			pkgID = bEntryOfinstalledBinary(filepath.Join(dir, name)).PkgID
+			pkgIDs[name] = pkgID
+		}
+		return pkgID
+	}
+}
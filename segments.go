@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/goccy/go-json"
+	"github.com/hedzr/progressbar"
+	"github.com/pkg/xattr"
+	"github.com/zeebo/blake3"
+)
+
+const segmentsXAttr = "user.dbin.segments"
+
+// segment is a part of a file that is downloaded on its own connection
+type segment struct {
+	Start   int64  `json:"start"`
+	End     int64  `json:"end"` // exclusive
+	Written int64  `json:"written"`
+	Digest  string `json:"digest"` // b3sum of the Written bytes that follow Start
+}
+
+// segmentsMeta is kept in the xattrs of the .tmp file of a segmented download, like resumeMeta is for single streams
+type segmentsMeta struct {
+	Source       string    `json:"source"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Size         int64     `json:"size"`
+	Segments     []segment `json:"segments"`
+}
+
+// rangeFetcher requests the bytes from first to last (inclusive) of the file that known describes
+type rangeFetcher func(ctx context.Context, first, last int64, known *resumeMeta) (*http.Response, error)
+
+func (m *segmentsMeta) save(tempFile string) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return xattr.Set(tempFile, segmentsXAttr, raw)
+}
+
+func (m *segmentsMeta) written() int64 {
+	var written int64
+	for _, seg := range m.Segments {
+		written += seg.Written
+	}
+	return written
+}
+
+// canSegment reports whether the download that resp starts is worth splitting, and can be
+func canSegment(resp *http.Response, meta *resumeMeta, cfg *config) bool {
+	if cfg.DownloadSegments < 2 || resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return false
+	}
+	if resp.ContentLength <= 0 || resp.ContentLength < 2*cfg.MinSegmentSize {
+		return false
+	}
+	// Plain files could change between the requests for their segments, unless If-Range can tell. OCI blobs can't
+	return meta.validator() != "" || strings.HasPrefix(meta.Source, "sha256:")
+}
+
+func newSegmentsMeta(resp *http.Response, meta *resumeMeta, cfg *config) *segmentsMeta {
+	size := resp.ContentLength
+	count := int64(cfg.DownloadSegments)
+	if cfg.MinSegmentSize > 0 {
+		count = min(count, size/cfg.MinSegmentSize)
+	}
+
+	m := &segmentsMeta{Source: meta.Source, ETag: meta.ETag, LastModified: meta.LastModified, Size: size}
+	length := size / count
+	for i := int64(0); i < count; i++ {
+		end := ternary(i == count-1, size, (i+1)*length)
+		m.Segments = append(m.Segments, segment{Start: i * length, End: end})
+	}
+	return m
+}
+
+// loadSegmentsMeta returns the segments of the interrupted download of source, if tempFile holds one. Segments
+// whose content doesn't match their checkpoint are started over, the whole file if it was left by another download
+func loadSegmentsMeta(tempFile, source string) *segmentsMeta {
+	raw, err := xattr.Get(tempFile, segmentsXAttr)
+	if err != nil {
+		return nil
+	}
+	var m segmentsMeta
+	if err := json.Unmarshal(raw, &m); err != nil || m.Source != source || len(m.Segments) == 0 {
+		os.Remove(tempFile)
+		return nil
+	}
+
+	file, err := os.Open(tempFile)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	for i := range m.Segments {
+		seg := &m.Segments[i]
+		if seg.Written == 0 {
+			continue
+		}
+		hash := blake3.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(file, seg.Start, seg.Written)); err != nil || hex.EncodeToString(hash.Sum(nil)) != seg.Digest {
+			seg.Written, seg.Digest = 0, ""
+		}
+	}
+	return &m
+}
+
+// downloadFile continues the download of resp into the .tmp file of destination, in segments that are
+// fetched concurrently if the file is large enough and the server takes ranges. A segmented download
+// that was interrupted is picked up with fetch, and resp is left unused
+func downloadFile(ctx context.Context, bar progressbar.PB, resp *http.Response, fetch rangeFetcher, destination string, bEntry *binaryEntry, cfg *config, meta resumeMeta) error {
+	tempFile := destination + ".tmp"
+	if m := loadSegmentsMeta(tempFile, meta.Source); m != nil {
+		resp.Body.Close()
+		return downloadSegmented(ctx, bar, nil, fetch, m, destination, bEntry, cfg)
+	}
+	if meta.Offset == 0 && canSegment(resp, &meta, cfg) {
+		return downloadSegmented(ctx, bar, resp, fetch, newSegmentsMeta(resp, &meta, cfg), destination, bEntry, cfg)
+	}
+	return downloadWithProgress(ctx, bar, resp, destination, bEntry, cfg, meta)
+}
+
+// downloadSegmented fetches the segments of m that are missing into the .tmp file, each at its place,
+// then verifies the whole file. first, if not nil, is a response that carries the file from its beginning
+func downloadSegmented(ctx context.Context, bar progressbar.PB, first *http.Response, fetch rangeFetcher, m *segmentsMeta, destination string, bEntry *binaryEntry, cfg *config) error {
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return errDownloadFailed.Wrap(err)
+	}
+
+	tempFile := destination + ".tmp"
+	flags := os.O_RDWR | os.O_CREATE | ternary(first != nil, os.O_TRUNC, 0)
+	out, err := os.OpenFile(tempFile, flags, 0644)
+	if err != nil {
+		return errDownloadFailed.Wrap(err)
+	}
+	defer out.Close()
+
+	xattr.Remove(tempFile, resumeXAttr)
+	if err := out.Truncate(m.Size); err != nil {
+		return errDownloadFailed.Wrap(err)
+	}
+	if err := m.save(tempFile); err != nil {
+		return errDownloadFailed.Wrap(err)
+	}
+
+	var progress io.Writer = io.Discard
+	if bar != nil {
+		bar.UpdateRange(0, m.Size)
+		bar.SetInitialValue(m.written())
+		progress = &lockedWriter{w: bar}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		pending  []int
+	)
+	for i, seg := range m.Segments {
+		if seg.Written < seg.End-seg.Start {
+			pending = append(pending, i)
+		}
+	}
+	if first != nil && (len(pending) == 0 || pending[0] != 0) {
+		first.Body.Close()
+		first = nil
+	}
+
+	// The download runs on the connection it was started with, each segment past the first one
+	// gets a connection of its own as long as the scheduler has a slot for it
+	workers := 1
+	for workers < len(pending) && bEntry.slots.tryAcquire() {
+		workers++
+	}
+	defer func() {
+		for range workers - 1 {
+			bEntry.slots.release()
+		}
+	}()
+
+	queue := make(chan int, len(pending))
+	for _, i := range pending {
+		queue <- i
+	}
+	close(queue)
+
+	known := &resumeMeta{Source: m.Source, ETag: m.ETag, LastModified: m.LastModified}
+	download := func(i int) error {
+		seg := &m.Segments[i]
+		var resp *http.Response
+		if i == 0 && first != nil {
+			resp = first
+		}
+
+		// Segments are only written back into m under mu, so that m can be saved at any time
+		checkpoint := func(written int64, digest string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			seg.Written, seg.Digest = written, digest
+			return m.save(tempFile)
+		}
+
+		mu.Lock()
+		current := *seg
+		mu.Unlock()
+		return downloadSegment(ctx, resp, fetch, known, out, current, progress, checkpoint)
+	}
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if err := download(i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		if errContentRange.Has(firstErr) {
+			// The file changed since the download started, it has to start over
+			os.Remove(tempFile)
+			return errTransient.Wrap(firstErr)
+		}
+		return firstErr
+	}
+
+	xattr.Remove(tempFile, segmentsXAttr)
+	hash, err := initializeHash(tempFile, m.Size, bEntry)
+	if err != nil {
+		return errDownloadFailed.Wrap(err)
+	}
+	return finishDownload(hash, tempFile, destination, bEntry, cfg)
+}
+
+// downloadSegment writes what is missing of seg into out, from resp if it is not nil, checkpointing as it goes
+func downloadSegment(ctx context.Context, resp *http.Response, fetch rangeFetcher, known *resumeMeta, out *os.File, seg segment, progress io.Writer, checkpoint func(int64, string) error) error {
+	length := seg.End - seg.Start
+	if resp == nil {
+		var err error
+		if resp, err = fetch(ctx, seg.Start+seg.Written, seg.End-1, known); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+	// Reads of a response that was requested with another context must be interrupted too
+	stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+	defer stop()
+
+	hash := blake3.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(out, seg.Start, seg.Written)); err != nil {
+		return errDownloadFailed.Wrap(err)
+	}
+
+	written, checkpointed := seg.Written, seg.Written
+	writer := io.MultiWriter(io.NewOffsetWriter(out, seg.Start+seg.Written), hash, progress)
+	reader := io.LimitReader(resp.Body, length-seg.Written)
+	buf := make([]byte, 64*1024)
+
+	for written < length {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			if _, errw := writer.Write(buf[:n]); errw != nil {
+				return errDownloadFailed.Wrap(errw)
+			}
+			written += int64(n)
+			if written-checkpointed >= resumeCheckpointInterval {
+				if err := checkpoint(written, hex.EncodeToString(hash.Sum(nil))); err != nil {
+					return errDownloadFailed.Wrap(err)
+				}
+				checkpointed = written
+			}
+		}
+		if err == io.EOF && written < length {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			checkpoint(written, hex.EncodeToString(hash.Sum(nil)))
+			return errDownloadFailed.Wrap(transientRequestError(ctx, err))
+		}
+	}
+
+	return checkpoint(written, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// fetchRange sends req for the bytes from first to last, and makes sure that they are what came back
+func fetchRange(do func(*http.Request) (*http.Response, error), req *http.Request, first, last int64, known *resumeMeta) (*http.Response, error) {
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first, last))
+	resp, err := do(req)
+	if err != nil {
+		return nil, errDownloadFailed.Wrap(err)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		resp.Body.Close()
+		return nil, errContentRange.New("the whole file was sent instead of the bytes %d-%d", first, last)
+	}
+	if err := checkStatus(resp, http.StatusPartialContent); err != nil {
+		resp.Body.Close()
+		return nil, errDownloadFailed.Wrap(err)
+	}
+	if err := checkResumed(resp, &resumeMeta{Offset: first, ETag: known.ETag}); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// httpRangeFetcher fetches the segments of a plain download, only as long as the file doesn't change (If-Range)
+func httpRangeFetcher(client *http.Client, url string) rangeFetcher {
+	return func(ctx context.Context, first, last int64, known *resumeMeta) (*http.Response, error) {
+		req, err := createHTTPRequest(ctx, "GET", url)
+		if err != nil {
+			return nil, errDownloadFailed.Wrap(err)
+		}
+		if validator := known.validator(); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+		return fetchRange(func(req *http.Request) (*http.Response, error) {
+			resp, err := client.Do(req)
+			return resp, transientRequestError(ctx, err)
+		}, req, first, last, known)
+	}
+}
+
+// ociRangeFetcher fetches the segments of an OCI blob
+func ociRangeFetcher(oci *ociClient, digest string) rangeFetcher {
+	return func(ctx context.Context, first, last int64, known *resumeMeta) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", oci.url("blobs", digest), nil)
+		if err != nil {
+			return nil, errOCILayerDownload.Wrap(err)
+		}
+		return fetchRange(oci.do, req, first, last, known)
+	}
+}
+
+// lockedWriter lets the segments of a download report their progress to the same bar
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
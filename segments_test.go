@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connectionCounter records how many requests a test server was serving at once, at most
+type connectionCounter struct {
+	mu              sync.Mutex
+	active, maxSeen int
+}
+
+func (c *connectionCounter) serve(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		c.active++
+		c.maxSeen = max(c.maxSeen, c.active)
+		c.mu.Unlock()
+		defer func() {
+			c.mu.Lock()
+			c.active--
+			c.mu.Unlock()
+		}()
+		// Long enough for the segments that may run concurrently to overlap
+		time.Sleep(50 * time.Millisecond)
+		next(w, r)
+	}
+}
+
+func TestSegmentedDownloadKeepsToSchedulerSlots(t *testing.T) {
+	payload, bsum := testPayload(4 * resumeCheckpointInterval)
+
+	for _, perHost := range []int{1, 2, 4} {
+		var counter connectionCounter
+		srv := httptest.NewServer(counter.serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "bin", time.Time{}, bytes.NewReader(payload))
+		}))
+		defer srv.Close()
+
+		cfg := &config{DownloadSegments: 4, MinSegmentSize: 1, MaxDownloadsPerHost: perHost}
+		host := downloadHost(srv.URL)
+		scheduler := newDownloadScheduler(cfg)
+		// The download itself holds a slot, as the scheduler started it
+		if !scheduler.tryAcquire(host) {
+			t.Fatal("no slot for the download")
+		}
+		bEntry := &binaryEntry{Name: "bin", Bsum: bsum, slots: connectionSlots{scheduler: scheduler, host: host}}
+
+		destination := filepath.Join(t.TempDir(), "bin")
+		meta := resumeMeta{Source: srv.URL}
+		resp, err := createDownloadRequest(context.Background(), srv.Client(), srv.URL, &meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !canSegment(resp, &meta, cfg) {
+			t.Fatal("the download is not segmented")
+		}
+		err = downloadFile(context.Background(), nil, resp, httpRangeFetcher(srv.Client(), srv.URL), destination, bEntry, cfg, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := os.ReadFile(destination)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%d per host: downloaded file differs from the original", perHost)
+		}
+		if counter.maxSeen > perHost {
+			t.Errorf("%d per host: the server saw %d connections at once", perHost, counter.maxSeen)
+		}
+		if perHost > 1 && counter.maxSeen < 2 {
+			t.Errorf("%d per host: the segments were not downloaded concurrently", perHost)
+		}
+		if n := scheduler.perHost[host]; n != 1 {
+			t.Errorf("%d per host: %d slots are taken after the download, want the one it was started with", perHost, n)
+		}
+	}
+}
+
+func TestDownloadHost(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/bin":           "example.com",
+		"http://127.0.0.1:8080/bin":         "127.0.0.1:8080",
+		"oci://ghcr.io/org/app:latest":      "ghcr.io",
+		"oci://localhost:5000/app@sha256:0": "localhost:5000",
+	}
+	for downloadURL, want := range tests {
+		if got := downloadHost(downloadURL); got != want {
+			t.Errorf("downloadHost(%q) = %q, want %q", downloadURL, got, want)
+		}
+	}
+}
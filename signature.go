@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jedisct1/go-minisign"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errIndexSignature = errs.Class("index signature verification failed")
+	errPublicKey      = errs.Class("invalid public key")
+)
+
+// parsePublicKey accepts both the bare base64 key and the usual two-line minisign.pub format
+func parsePublicKey(data []byte) (minisign.PublicKey, error) {
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, "untrusted comment:") {
+		return minisign.DecodePublicKey(text)
+	}
+	return minisign.NewPublicKey(text)
+}
+
+// loadPublicKey returns the key called name, out of the ones listed in repo.PubKeys. A full key pinned
+// in the config is used as is, any other key has to go through the trust store (see trustStore.go)
+func loadPublicKey(cfg *config, repo repository, name string) (minisign.PublicKey, error) {
+	if pubKey, err := parsePublicKey([]byte(repo.PinnedKeys[name])); err == nil {
+		return pubKey, nil
+	}
+
+	pubKeyURL := repo.PubKeys[name]
+	if pubKeyURL == "" {
+		return minisign.PublicKey{}, errPublicKey.New("no public key named %q", name)
+	}
+
+	pubKeyData, err := accessCachedOrFetch([]string{pubKeyURL}, pubKeyCacheName(repo, name), cfg, &repo, repo.SyncInterval, nil)
+	if err != nil {
+		// The key we already trust remains good while its URL is unreachable
+		if pubKey, ok := trustedKeyOf(cfg, repo, name); ok {
+			return pubKey, nil
+		}
+		return minisign.PublicKey{}, errPublicKey.Wrap(err)
+	}
+
+	pubKey, err := parsePublicKey(pubKeyData)
+	if err != nil {
+		return minisign.PublicKey{}, errPublicKey.New("%s: %v", pubKeyURL, err)
+	}
+	return resolveTrustedKey(cfg, repo, name, pubKey, pubKeyData)
+}
+
+// fetchSignature gets the detached signature (<url>.sig) of a file. found is false if there is none
+func fetchSignature(client *http.Client, url string) (sigData []byte, found bool, err error) {
+	sigURL := url + ".sig"
+
+	if path, ok := strings.CutPrefix(sigURL, "file://"); ok {
+		sigData, err = os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return sigData, err == nil, err
+	}
+
+	req, err := createHTTPRequest(context.Background(), "GET", sigURL)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden, http.StatusGone:
+		return nil, false, nil
+	default:
+		return nil, false, errs.New("[%d] %s", resp.StatusCode, sigURL)
+	}
+
+	sigData, err = io.ReadAll(resp.Body)
+	return sigData, err == nil, err
+}
+
+// servedByFallback reports whether url is one of the fallback URLs of repo, or a file published next to one
+func servedByFallback(repo repository, url string) bool {
+	publishedBy := func(indexURL string) bool {
+		return url == indexURL || strings.HasPrefix(url, indexBaseName(indexURL)+".")
+	}
+	if publishedBy(repo.URL) {
+		return false
+	}
+	for _, fallback := range repo.FallbackURLs {
+		if publishedBy(fallback) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyIndexSignature checks body, fetched from indexURL, against its detached signature.
+// Unsigned indexes are only accepted from the repository itself, if it does not require them to be
+// signed. Fallback mirrors must always serve signed indexes, and a signature that is present and
+// does not verify is always rejected
+func verifyIndexSignature(cfg *config, repo repository, indexURL string, body []byte) error {
+	fallback := servedByFallback(repo, indexURL)
+	required := repo.RequireIndexSignature || fallback
+	if len(repo.PubKeys) == 0 {
+		if fallback {
+			return errIndexSignature.New("%s is a fallback of %s, which has no pubKeys to check it with", indexURL, repo.URL)
+		}
+		if required {
+			return errIndexSignature.New("%s requires a signed index, but it has no pubKeys", repo.URL)
+		}
+		return nil
+	}
+
+	sigData, found, err := fetchSignature(httpClient(cfg, &repo), indexURL)
+	if err != nil {
+		if required {
+			return errIndexSignature.Wrap(err)
+		}
+		if verbosityLevel >= extraVerbose {
+			fmt.Fprintf(os.Stderr, "Warning: could not fetch the signature of %s: %v\n", indexURL, err)
+		}
+		return nil
+	}
+	if !found {
+		if required {
+			return errIndexSignature.New("%s is not signed", indexURL)
+		}
+		return nil
+	}
+
+	sig, err := minisign.DecodeSignature(string(sigData))
+	if err != nil {
+		return errIndexSignature.Wrap(err)
+	}
+
+	names := make([]string, 0, len(repo.PubKeys))
+	for name := range repo.PubKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// A key that can't be loaded may be the one that signed the index
+	var loadErrs []error
+	for _, name := range names {
+		pubKey, err := loadPublicKey(cfg, repo, name)
+		if err != nil {
+			loadErrs = append(loadErrs, err)
+			continue
+		}
+		if !bytes.Equal(pubKey.KeyId[:], sig.KeyId[:]) {
+			continue
+		}
+		verified, err := pubKey.Verify(body, sig)
+		if err != nil {
+			return errIndexSignature.Wrap(err)
+		}
+		if !verified {
+			return errIndexSignature.New("signature of %s is invalid", indexURL)
+		}
+		return nil
+	}
+
+	if len(loadErrs) > 0 {
+		return errIndexSignature.New("could not check the signature of %s: %v", indexURL, errors.Join(loadErrs...))
+	}
+	return errIndexSignature.New("%s was signed by a key that is not in the pubKeys of its repository", indexURL)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServedByFallback(t *testing.T) {
+	repo := repository{
+		URL:          "https://example.com/amd64_Linux.json",
+		FallbackURLs: []string{"https://mirror.example.org/amd64_Linux.json.zst", "https://example.com/amd64_Linux2.json"},
+	}
+
+	tests := map[string]bool{
+		"https://example.com/amd64_Linux.json":                  false,
+		"https://example.com/amd64_Linux.timestamp.json":        false,
+		"https://example.com/amd64_Linux.delta.3.json":          false,
+		"https://mirror.example.org/amd64_Linux.json.zst":       true,
+		"https://mirror.example.org/amd64_Linux.timestamp.json": true,
+		"https://mirror.example.org/amd64_Linux.delta.3.json":   true,
+		"https://example.com/amd64_Linux2.json":                 true,
+		"https://example.com/amd64_Linux2.revocations":          true,
+		"https://elsewhere.example.net/amd64_Linux.json":        false,
+	}
+	for url, want := range tests {
+		if got := servedByFallback(repo, url); got != want {
+			t.Errorf("servedByFallback(%q) = %v, want %v", url, got, want)
+		}
+	}
+}
+
+func TestVerifyIndexSignature(t *testing.T) {
+	dir := t.TempDir()
+	index := filepath.Join(dir, "index.json")
+	mirror := filepath.Join(dir, "mirror", "index.json")
+	signedMirror := filepath.Join(dir, "signed", "index.json")
+	for _, path := range []string{index, mirror, signedMirror} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Well formed, but by a key we can't load
+	sig := "untrusted comment: test\n" + base64.StdEncoding.EncodeToString(append([]byte("Ed"), make([]byte, 72)...)) +
+		"\ntrusted comment: test\n" + base64.StdEncoding.EncodeToString(make([]byte, 64)) + "\n"
+	if err := os.WriteFile(signedMirror+".sig", []byte(sig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config{CacheDir: filepath.Join(dir, "cache"), TrustStore: filepath.Join(dir, "trusted_keys.yaml")}
+	unkeyed := repository{URL: "file://" + index, FallbackURLs: []string{"file://" + mirror, "file://" + signedMirror}}
+	keyed := unkeyed
+	keyed.PubKeys = map[string]string{"main": "file://" + filepath.Join(dir, "missing.pub")}
+
+	tests := []struct {
+		name    string
+		repo    repository
+		url     string
+		wantErr string
+	}{
+		{"unsigned index without pubKeys", unkeyed, index, ""},
+		{"unsigned fallback without pubKeys", unkeyed, mirror, "no pubKeys"},
+		{"unsigned index", keyed, index, ""},
+		{"unsigned fallback", keyed, mirror, "is not signed"},
+		{"key that can't be loaded", keyed, signedMirror, "missing.pub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyIndexSignature(cfg, tt.repo, "file://"+tt.url, []byte("{}"))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got %v, want no error", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got %v, want an error about %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/xattr"
+	"github.com/urfave/cli/v3"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errSyncFailed = errs.Class("sync failed")
+)
+
+const cacheXAttr = "user.dbin.cache"
+
+// cacheMeta is kept in the xattrs of a cached file, so that the URL that served it
+// can later be asked whether it changed, instead of sending it again
+type cacheMeta struct {
+	Source       string    `json:"source"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Version      uint64    `json:"version,omitempty"` // of the index, see repoDelta.go
+	Fetched      time.Time `json:"fetched"`
+}
+
+func (m *cacheMeta) save(cacheFile string) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return xattr.Set(cacheFile, cacheXAttr, raw)
+}
+
+func loadCacheMeta(cacheFile string) cacheMeta {
+	var meta cacheMeta
+	if raw, err := xattr.Get(cacheFile, cacheXAttr); err == nil {
+		_ = json.Unmarshal(raw, &meta)
+	}
+	return meta
+}
+
+// setConditionalHeaders makes req a conditional request, if the cached copy came from url
+func (m *cacheMeta) setConditionalHeaders(req *http.Request, url string) {
+	if m.Source != url {
+		return
+	}
+	if m.ETag != "" {
+		req.Header.Set("If-None-Match", m.ETag)
+	}
+	if m.LastModified != "" {
+		req.Header.Set("If-Modified-Since", m.LastModified)
+	}
+}
+
+func syncCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "sync",
+		Usage: "Refresh the index of every repository, regardless of its syncInterval",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:    "force",
+				Aliases: []string{"f"},
+				Usage:   "Download the indexes again, even if they didn't change",
+			},
+		},
+		Action: func(_ context.Context, c *cli.Command) error {
+			config, err := loadConfig()
+			if err != nil {
+				return errSyncFailed.Wrap(err)
+			}
+			return syncRepositories(config, c.Bool("force"))
+		},
+	}
+}
+
+// syncRepositories refreshes the index of every repository (and the files that come with it), then
+// reports how old and how big each cached index is, and whether it changed
+func syncRepositories(config *config, force bool) error {
+	var errors []string
+	synced := make(map[string]bool)
+
+	for _, repo := range config.Repositories {
+		if synced[repo.URL] || !repo.enabled() {
+			continue
+		}
+		synced[repo.URL] = true
+		repo.SyncInterval, repo.forceSync = 0, force
+
+		// The index is cached as it was served, and in the canonical form deltas are applied to
+		cacheFiles := []string{cachePath(config, repo.URL, ""), deltaBasePath(config, repo)}
+		before := make([]string, len(cacheFiles))
+		for i, cacheFile := range cacheFiles {
+			before[i], _ = calculateChecksum(cacheFile)
+		}
+
+		entries, err := decodeRepository(config, repo)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", repo.URL, err))
+			continue
+		}
+		if verbosityLevel < normalVerbosity {
+			continue
+		}
+
+		if strings.HasPrefix(repo.URL, "file://") {
+			fmt.Printf("%s: local, %d binaries\n", repo.URL, len(entries))
+			continue
+		}
+		status := "unchanged"
+		newest, meta := cacheFiles[0], loadCacheMeta(cacheFiles[0])
+		for i, cacheFile := range cacheFiles {
+			if after, _ := calculateChecksum(cacheFile); after != before[i] {
+				status = ternary(i == 0, "updated", "updated with deltas")
+				break
+			}
+		}
+		if m := loadCacheMeta(cacheFiles[1]); m.Fetched.After(meta.Fetched) {
+			newest, meta = cacheFiles[1], m
+		}
+		info, err := os.Stat(newest)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", repo.URL, err))
+			continue
+		}
+
+		age := "unknown age"
+		if !meta.Fetched.IsZero() {
+			age = "fetched " + time.Since(meta.Fetched).Round(time.Second).String() + " ago"
+		}
+		fmt.Printf("%s: %s, %s, %s, %d binaries\n", repo.URL, status, formatSize(info.Size()), age, len(entries))
+	}
+
+	if len(errors) > 0 {
+		return errSyncFailed.New("%s", strings.Join(errors, "\n"))
+	}
+	return nil
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
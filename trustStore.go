@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/jedisct1/go-minisign"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errTrustStore  = errs.Class("trust store error")
+	errKeyChanged  = errs.Class("public key changed")
+	errKeyMismatch = errs.Class("public key does not match its pin")
+	trustStoreMu   sync.Mutex
+)
+
+// trustedKey is a public key that was trusted on first use, or accepted through `dbin repo trust`
+type trustedKey struct {
+	KeyID     string    `yaml:"keyID"`
+	PublicKey string    `yaml:"publicKey"`
+	Source    string    `yaml:"source"`
+	Added     time.Time `yaml:"added"`
+}
+
+// keyID formats the ID of a minisign key the same way `minisign` itself does
+func keyID(id [8]byte) string {
+	reversed := make([]byte, len(id))
+	for i := range id {
+		reversed[i] = id[len(id)-1-i]
+	}
+	return strings.ToUpper(hex.EncodeToString(reversed))
+}
+
+func encodePublicKey(pubKey minisign.PublicKey) string {
+	var buf bytes.Buffer
+	buf.Write(pubKey.SignatureAlgorithm[:])
+	buf.Write(pubKey.KeyId[:])
+	buf.Write(pubKey.PublicKey[:])
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func readTrustStore(cfg *config) (map[string]trustedKey, error) {
+	store := make(map[string]trustedKey)
+	data, err := os.ReadFile(cfg.TrustStore)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, errTrustStore.Wrap(err)
+	}
+	if err := yaml.Unmarshal(data, &store); err != nil {
+		return nil, errTrustStore.Wrap(err)
+	}
+	return store, nil
+}
+
+func writeTrustStore(cfg *config, store map[string]trustedKey) error {
+	data, err := yaml.Marshal(store)
+	if err != nil {
+		return errTrustStore.Wrap(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.TrustStore), 0755); err != nil {
+		return errTrustStore.Wrap(err)
+	}
+	tempFile := cfg.TrustStore + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return errTrustStore.Wrap(err)
+	}
+	return os.Rename(tempFile, cfg.TrustStore)
+}
+
+// trustStoreKey identifies the key called name in the trust store. Key names are only unique within a
+// repository, so they go with the URL of its index
+func trustStoreKey(repo repository, name string) string {
+	return repo.URL + "#" + name
+}
+
+// pubKeyCacheName is the name the key called name of repo is cached under
+func pubKeyCacheName(repo repository, name string) string {
+	sum := blake3.Sum256([]byte(repo.URL))
+	return name + "." + hex.EncodeToString(sum[:4]) + ".minisign"
+}
+
+// lookupTrustedKey returns the entry of store for the key called name of repo. Entries recorded before
+// the trust store was keyed by repository are taken as long as they were fetched from the same URL
+func lookupTrustedKey(store map[string]trustedKey, repo repository, name string) (trustedKey, bool) {
+	if trusted, known := store[trustStoreKey(repo, name)]; known {
+		return trusted, true
+	}
+	if trusted, known := store[name]; known && trusted.Source == repo.PubKeys[name] {
+		return trusted, true
+	}
+	return trustedKey{}, false
+}
+
+// trustKey records pubKey as the trusted key called name of repo. trustStoreMu must be held
+func trustKey(cfg *config, repo repository, name string, pubKey minisign.PublicKey) error {
+	store, err := readTrustStore(cfg)
+	if err != nil {
+		return err
+	}
+	if legacy, known := store[name]; known && legacy.Source == repo.PubKeys[name] {
+		delete(store, name)
+	}
+	store[trustStoreKey(repo, name)] = trustedKey{
+		KeyID:     keyID(pubKey.KeyId),
+		PublicKey: encodePublicKey(pubKey),
+		Source:    repo.PubKeys[name],
+		Added:     time.Now().UTC(),
+	}
+	return writeTrustStore(cfg, store)
+}
+
+// checkPin compares pubKey against what the config pins for it, if anything
+func checkPin(repo repository, name string, pubKey minisign.PublicKey) error {
+	pin := repo.PinnedKeys[name]
+	if pin == "" {
+		return nil
+	}
+	if pinnedKey, err := parsePublicKey([]byte(pin)); err == nil {
+		if pinnedKey != pubKey {
+			return errKeyMismatch.New("%q is pinned to %s, but %s was served", name, keyID(pinnedKey.KeyId), keyID(pubKey.KeyId))
+		}
+		return nil
+	}
+	if !strings.EqualFold(pin, keyID(pubKey.KeyId)) {
+		return errKeyMismatch.New("%q is pinned to %s, but %s was served", name, strings.ToUpper(pin), keyID(pubKey.KeyId))
+	}
+	return nil
+}
+
+// readTrustedKey returns the entry of the trust store for the key called name of repo, if there is one
+func readTrustedKey(cfg *config, repo repository, name string) (trustedKey, bool, error) {
+	trustStoreMu.Lock()
+	defer trustStoreMu.Unlock()
+
+	store, err := readTrustStore(cfg)
+	if err != nil {
+		return trustedKey{}, false, err
+	}
+	trusted, known := lookupTrustedKey(store, repo, name)
+	return trusted, known, nil
+}
+
+// recordTrustedKey records pubKey for the key called name of repo, in place of previous. If the trust store
+// no longer holds previous for it, the key was decided on meanwhile, and pubKey is only fine if it is the same
+func recordTrustedKey(cfg *config, repo repository, name string, previous trustedKey, pubKey minisign.PublicKey) error {
+	trustStoreMu.Lock()
+	defer trustStoreMu.Unlock()
+
+	store, err := readTrustStore(cfg)
+	if err != nil {
+		return err
+	}
+	current, _ := lookupTrustedKey(store, repo, name)
+	switch current.PublicKey {
+	case encodePublicKey(pubKey):
+		return nil
+	case previous.PublicKey:
+		return trustKey(cfg, repo, name, pubKey)
+	}
+	return errKeyChanged.New("the trusted key of %q changed to %s while %s was being checked", name, current.KeyID, keyID(pubKey.KeyId))
+}
+
+// resolveTrustedKey decides whether the key that was just fetched for name can be used. Unknown keys are
+// trusted on first use, and a changed key is only accepted if it was signed (<pubkey URL>.sig) by the
+// previously trusted one, which is how repositories rotate their keys
+func resolveTrustedKey(cfg *config, repo repository, name string, fetched minisign.PublicKey, fetchedData []byte) (minisign.PublicKey, error) {
+	if err := checkPin(repo, name, fetched); err != nil {
+		return minisign.PublicKey{}, err
+	}
+
+	trusted, known, err := readTrustedKey(cfg, repo, name)
+	if err != nil {
+		return minisign.PublicKey{}, err
+	}
+	if !known {
+		if verbosityLevel >= normalVerbosity {
+			fmt.Fprintf(os.Stderr, "Trusting public key %s for %q on first use\n", keyID(fetched.KeyId), name)
+		}
+		return fetched, recordTrustedKey(cfg, repo, name, trusted, fetched)
+	}
+
+	trustedPubKey, err := minisign.NewPublicKey(trusted.PublicKey)
+	if err != nil {
+		return minisign.PublicKey{}, errTrustStore.New("%s: entry %q: %v", cfg.TrustStore, trustStoreKey(repo, name), err)
+	}
+	if trustedPubKey == fetched {
+		return trustedPubKey, nil
+	}
+
+	// The trust store isn't held while the signature is fetched, the key servers of one repository
+	// shouldn't hold up the others
+	if sigData, found, err := fetchSignature(httpClient(cfg, &repo), repo.PubKeys[name]); err == nil && found {
+		if sig, err := minisign.DecodeSignature(string(sigData)); err == nil {
+			if verified, _ := trustedPubKey.Verify(fetchedData, sig); verified {
+				if verbosityLevel >= normalVerbosity {
+					fmt.Fprintf(os.Stderr, "Public key of %q rotated from %s to %s\n", name, keyID(trustedPubKey.KeyId), keyID(fetched.KeyId))
+				}
+				return fetched, recordTrustedKey(cfg, repo, name, trusted, fetched)
+			}
+		}
+	}
+
+	return minisign.PublicKey{}, errKeyChanged.New("the public key of %q changed from %s to %s. If this is expected, accept it with `dbin repo trust %s`", name, keyID(trustedPubKey.KeyId), keyID(fetched.KeyId), name)
+}
+
+// trustedKeyOf returns the key recorded for the key called name of repo in the trust store, if any
+func trustedKeyOf(cfg *config, repo repository, name string) (minisign.PublicKey, bool) {
+	trusted, known, err := readTrustedKey(cfg, repo, name)
+	if err != nil || !known {
+		return minisign.PublicKey{}, false
+	}
+	pubKey, err := minisign.NewPublicKey(trusted.PublicKey)
+	return pubKey, err == nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testKey is a minisign key pair, which go-minisign can only verify with
+type testKey struct {
+	id   [8]byte
+	priv ed25519.PrivateKey
+}
+
+func newTestKey(t *testing.T, id byte) testKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{id: [8]byte{id}, priv: priv}
+}
+
+func (k testKey) publicKey() []byte {
+	key := append(append([]byte("Ed"), k.id[:]...), k.priv.Public().(ed25519.PublicKey)...)
+	return []byte("untrusted comment: test key\n" + base64.StdEncoding.EncodeToString(key) + "\n")
+}
+
+func (k testKey) sign(data []byte) []byte {
+	sig := ed25519.Sign(k.priv, data)
+	global := ed25519.Sign(k.priv, append(sig, "test"...))
+	return []byte("untrusted comment: test signature\n" + base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), k.id[:]...), sig...)) +
+		"\ntrusted comment: test\n" + base64.StdEncoding.EncodeToString(global) + "\n")
+}
+
+// trustStoreFixture serves the key "main" of a repository, which the tests replace
+type trustStoreFixture struct {
+	cfg  *config
+	repo repository
+	dir  string // served
+	// Versions of the key served so far, each one gets its own modification time, or the
+	// server would answer that it wasn't modified
+	versions int
+}
+
+func newTrustStoreFixture(t *testing.T) *trustStoreFixture {
+	dir := t.TempDir()
+	srv := httptest.NewServer(http.FileServer(http.Dir(filepath.Join(dir, "www"))))
+	t.Cleanup(srv.Close)
+	if err := os.Mkdir(filepath.Join(dir, "www"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &trustStoreFixture{dir: filepath.Join(dir, "www")}
+	f.repo = repository{URL: srv.URL + "/index.json", PubKeys: map[string]string{"main": srv.URL + "/main.pub"}}
+	f.cfg = &config{CacheDir: filepath.Join(dir, "cache"), TrustStore: filepath.Join(dir, "trusted_keys.yaml"), Repositories: []repository{f.repo}}
+	return f
+}
+
+// serve publishes key as "main", with sig as its signature, if there is one
+func (f *trustStoreFixture) serve(t *testing.T, key, sig []byte) {
+	t.Helper()
+	path := filepath.Join(f.dir, "main.pub")
+	if err := os.WriteFile(path, key, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.versions++
+	modified := time.Now().Add(time.Duration(f.versions-100) * time.Minute)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path + ".sig")
+	if sig != nil {
+		if err := os.WriteFile(path+".sig", sig, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (f *trustStoreFixture) trusted(t *testing.T) string {
+	t.Helper()
+	trusted, known, err := readTrustedKey(f.cfg, f.repo, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ternary(known, trusted.KeyID, "")
+}
+
+func TestTrustOnFirstUseAndRotation(t *testing.T) {
+	f := newTrustStoreFixture(t)
+	first, second, rogue := newTestKey(t, 1), newTestKey(t, 2), newTestKey(t, 3)
+
+	// The first key seen is recorded
+	f.serve(t, first.publicKey(), nil)
+	if _, err := loadPublicKey(f.cfg, f.repo, "main"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.trusted(t), keyID(first.id); got != want {
+		t.Fatalf("trusted %q on first use, want %s", got, want)
+	}
+
+	// A different key, with no signature or one by a key that isn't trusted, is refused
+	for name, sig := range map[string][]byte{"unsigned": nil, "signed by itself": second.sign(second.publicKey()), "signed by another key": rogue.sign(second.publicKey())} {
+		f.serve(t, second.publicKey(), sig)
+		if _, err := loadPublicKey(f.cfg, f.repo, "main"); !errKeyChanged.Has(err) {
+			t.Errorf("%s: loading a changed key: %v, want errKeyChanged", name, err)
+		}
+		if got, want := f.trusted(t), keyID(first.id); got != want {
+			t.Errorf("%s: trusting %s, want %s still", name, got, want)
+		}
+	}
+
+	// unless the trusted key signed it
+	f.serve(t, second.publicKey(), first.sign(second.publicKey()))
+	pubKey, err := loadPublicKey(f.cfg, f.repo, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID(pubKey.KeyId) != keyID(second.id) || f.trusted(t) != keyID(second.id) {
+		t.Errorf("loaded %s and trusting %s after a signed rotation, want %s", keyID(pubKey.KeyId), f.trusted(t), keyID(second.id))
+	}
+
+	// or the user accepts it
+	f.serve(t, rogue.publicKey(), nil)
+	if err := trustRepoKeys(f.cfg, []string{"main"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPublicKey(f.cfg, f.repo, "main"); err != nil {
+		t.Errorf("loading the key accepted with `dbin repo trust`: %v", err)
+	}
+	if got, want := f.trusted(t), keyID(rogue.id); got != want {
+		t.Errorf("trusting %s after `dbin repo trust`, want %s", got, want)
+	}
+}
+
+func TestPinnedKeys(t *testing.T) {
+	served, other := newTestKey(t, 1), newTestKey(t, 2)
+
+	tests := []struct {
+		name    string
+		pin     string
+		want    string // ID of the key that is used
+		wantErr bool
+	}{
+		{"ID of the served key", keyID(served.id), keyID(served.id), false},
+		{"ID of another key", keyID(other.id), "", true},
+		{"another full key", string(other.publicKey()), keyID(other.id), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTrustStoreFixture(t)
+			f.repo.PinnedKeys = map[string]string{"main": tt.pin}
+			f.serve(t, served.publicKey(), nil)
+
+			pubKey, err := loadPublicKey(f.cfg, f.repo, "main")
+			if tt.wantErr {
+				if !errKeyMismatch.Has(err) {
+					t.Errorf("got %v, want errKeyMismatch", err)
+				}
+				if f.trusted(t) != "" {
+					t.Error("recorded a key that does not match its pin")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := keyID(pubKey.KeyId); got != tt.want {
+				t.Errorf("using %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -34,7 +34,7 @@ func updateCommand() *cli.Command {
 	}
 }
 
-func update(config *config, programsToUpdate []binaryEntry, uRepoIndex []binaryEntry) error {
+func update(config *config, programsToUpdate []binaryEntry, uRepoIndex *repoIndex) error {
 	var (
 		skipped, updated, errors uint32
 		checked                  uint32
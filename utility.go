@@ -2,13 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/breml/rootcerts" // built-in ca certs
@@ -164,12 +169,11 @@ func binaryEntriesToArrString(entries []binaryEntry, ansi bool) []string {
 	return result
 }
 
-func validateProgramsFrom(config *config, programsToValidate []binaryEntry, uRepoIndex []binaryEntry) ([]binaryEntry, error) {
+func validateProgramsFrom(config *config, programsToValidate []binaryEntry, uRepoIndex *repoIndex) ([]binaryEntry, error) {
 	var (
-		programsEntries []binaryEntry
-		validPrograms   []binaryEntry
-		err             error
-		files           []string
+		validPrograms []binaryEntry
+		err           error
+		files         []string
 	)
 
 	if config.RetakeOwnership {
@@ -180,8 +184,7 @@ func validateProgramsFrom(config *config, programsToValidate []binaryEntry, uRep
 			}
 		}
 
-		programsEntries, err = listBinaries(uRepoIndex)
-		if err != nil {
+		if _, err = listBinaries(uRepoIndex.all()); err != nil {
 			return nil, fmt.Errorf("failed to list remote binaries: %w", err)
 		}
 	}
@@ -222,11 +225,8 @@ func validateProgramsFrom(config *config, programsToValidate []binaryEntry, uRep
 				trackedBEntry.PkgID = "!retake"
 			}
 
-			for j := range programsEntries {
-				if programsEntries[j].Name == trackedBEntry.Name {
-					validPrograms = append(validPrograms, trackedBEntry)
-					break
-				}
+			if len(uRepoIndex.named(trackedBEntry.Name)) > 0 {
+				validPrograms = append(validPrograms, trackedBEntry)
 			}
 			continue
 		}
@@ -240,11 +240,8 @@ func validateProgramsFrom(config *config, programsToValidate []binaryEntry, uRep
 			validPrograms = append(validPrograms, trackedBEntry)
 			continue
 		}
-		for j := range uRepoIndex {
-			if uRepoIndex[j].Name == trackedBEntry.Name && uRepoIndex[j].PkgID == trackedBEntry.PkgID {
-				validPrograms = append(validPrograms, trackedBEntry)
-				break
-			}
+		if uRepoIndex.contains(trackedBEntry.Name, trackedBEntry.PkgID) {
+			validPrograms = append(validPrograms, trackedBEntry)
 		}
 	}
 
@@ -349,6 +346,16 @@ func embedBEntry(binaryPath string, bEntry binaryEntry) error {
 	if err := xattr.Set(binaryPath, "user.FullName", []byte(parseBinaryEntry(bEntry, false))); err != nil {
 		return errXAttr.Wrap(err)
 	}
+	if len(bEntry.verifiedDigests) > 0 {
+		if err := xattr.Set(binaryPath, "user.dbin.digests", []byte(strings.Join(bEntry.verifiedDigests, ","))); err != nil {
+			return errXAttr.Wrap(err)
+		}
+	}
+	if bEntry.localBsum != "" {
+		if err := xattr.Set(binaryPath, "user.dbin.bsum", []byte(bEntry.localBsum)); err != nil {
+			return errXAttr.Wrap(err)
+		}
+	}
 	return nil
 }
 
@@ -364,11 +371,26 @@ func readEmbeddedBEntry(binaryPath string) (binaryEntry, error) {
 
 	bEntry := stringToBinaryEntry(string(fullName))
 	bEntry.binaryPath = binaryPath
+	if digests, err := xattr.Get(binaryPath, "user.dbin.digests"); err == nil && len(digests) > 0 {
+		bEntry.verifiedDigests = strings.Split(string(digests), ",")
+	}
+	if bsum, err := xattr.Get(binaryPath, "user.dbin.bsum"); err == nil {
+		bEntry.localBsum = string(bsum)
+	}
 
 	return bEntry, nil
 }
 
-func accessCachedOrFetch(urls []string, filename string, cfg *config, syncInterval time.Duration) ([]byte, error) {
+// cachePath returns where the copy of url (stored as filename, if not empty) is cached
+func cachePath(cfg *config, url, filename string) string {
+	return filepath.Join(cfg.CacheDir, ternary(filename != "", "."+filename, "."+filepath.Base(url)))
+}
+
+// accessCachedOrFetch returns the cached copy of urls[0] if it is younger than syncInterval, otherwise
+// it fetches it, trying the fallbacks in order. The URL that served the cached copy is asked whether it
+// changed (If-None-Match, If-Modified-Since), unless the repo is being forcibly synced.
+// If validate is not nil, a body is only accepted (and cached) if it passes it
+func accessCachedOrFetch(urls []string, filename string, cfg *config, repo *repository, syncInterval time.Duration, validate func(url string, body []byte) error) ([]byte, error) {
 	if len(urls) == 0 {
 		return nil, errNoURLs.Wrap(errs.New("urls: []string contains no URLs"))
 	}
@@ -378,153 +400,398 @@ func accessCachedOrFetch(urls []string, filename string, cfg *config, syncInterv
 		fallbacks = urls[1:]
 	}
 
-	cacheFilePath := filepath.Join(cfg.CacheDir, ternary(filename != "", "."+filename, "."+filepath.Base(mainURL)))
+	cacheFilePath := cachePath(cfg, mainURL, filename)
 
 	if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
 		return nil, errCacheAccess.Wrap(err)
 	}
 
-	if info, err := os.Stat(cacheFilePath); err == nil && time.Since(info.ModTime()) < syncInterval {
+	if info, err := os.Stat(cacheFilePath); err == nil && (repo.cachedOnly || time.Since(info.ModTime()) < syncInterval) {
 		data, err := os.ReadFile(cacheFilePath)
 		if err != nil {
 			return nil, errCacheAccess.Wrap(err)
 		}
+		cfg.indexExpiry.observe(info.ModTime().Add(syncInterval))
+		if len(data) == 0 && repo.optional {
+			return nil, errFileNotFound.New("%s is not published", mainURL)
+		}
 		return data, nil
+	} else if repo.cachedOnly {
+		return nil, errCacheAccess.New("%s was never fetched", mainURL)
 	}
+	defer cfg.indexExpiry.observe(time.Now().Add(syncInterval))
 
-	tryFetch := func(u string) ([]byte, int, error) {
-		req, err := http.NewRequest("GET", u, nil)
-		if err != nil {
-			return nil, -1, err
+	var meta cacheMeta
+	if !repo.forceSync {
+		meta = loadCacheMeta(cacheFilePath)
+	}
+
+	tryFetch := func(u string) ([]byte, http.Header, int, error) {
+		var (
+			body   []byte
+			header http.Header
+			code   int
+		)
+		err := withRetries(context.Background(), cfg, u, func() error {
+			code = 0
+			req, err := http.NewRequest("GET", u, nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("dbin", strconv.FormatFloat(version, 'f', -1, 32))
+			meta.setConditionalHeaders(req, u)
+
+			resp, err := httpClient(cfg, repo).Do(req)
+			if err != nil {
+				return errTransient.Wrap(err)
+			}
+			defer resp.Body.Close()
+
+			if retryableStatus(resp.StatusCode) {
+				code = resp.StatusCode
+				return checkStatus(resp)
+			}
+			if body, err = io.ReadAll(resp.Body); err != nil {
+				return errTransient.Wrap(err)
+			}
+			header, code = resp.Header, resp.StatusCode
+			return nil
+		})
+		if err != nil && code == 0 {
+			return nil, nil, -1, err
 		}
-		req.Header.Set("Cache-Control", "no-cache, no-store, must-revalidate")
-		req.Header.Set("Pragma", "no-cache")
-		req.Header.Set("dbin", strconv.FormatFloat(version, 'f', -1, 32))
+		return body, header, code, nil
+	}
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, -1, err
+	accept := func(u string, body []byte, header http.Header) bool {
+		if validate != nil {
+			if err := validate(u, body); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Rejected %s: %v\n", u, err)
+				return false
+			}
 		}
-		defer resp.Body.Close()
+		_ = os.WriteFile(cacheFilePath, body, 0644)
+		meta := cacheMeta{Source: u, ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified"), Fetched: time.Now()}
+		_ = meta.save(cacheFilePath)
+		return true
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		return body, resp.StatusCode, err
+	// notModified returns the cached copy, which is up to date once again
+	notModified := func() ([]byte, bool) {
+		data, err := os.ReadFile(cacheFilePath)
+		if err != nil {
+			return nil, false
+		}
+		now := time.Now()
+		_ = os.Chtimes(cacheFilePath, now, now)
+		return data, true
+	}
+
+	// missing tells whether every URL answered that there is no such file, which documents
+	// that a repository may not publish are allowed to, without warnings
+	missing := true
+	warn := func(code int, format string, a ...any) {
+		if code != http.StatusNotFound && code != http.StatusGone {
+			missing = false
+		} else if repo.optional && verbosityLevel < extraVerbose {
+			return
+		}
+		fmt.Fprintf(os.Stderr, format, a...)
 	}
 
 	// Try main
-	body, code, err := tryFetch(mainURL)
+	body, header, code, err := tryFetch(mainURL)
 	if err == nil && code == http.StatusOK {
-		_ = os.WriteFile(cacheFilePath, body, 0644)
-		return body, nil
-	}
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: [nil] Failed to fetch: %s\n", mainURL)
-	} else if code != http.StatusTooManyRequests {
-		fmt.Fprintf(os.Stderr, "Warning: [%d] Failed to fetch: %s\n", code, mainURL)
+		if accept(mainURL, body, header) {
+			return body, nil
+		}
+		missing = false
+	} else if err == nil && code == http.StatusNotModified {
+		if data, ok := notModified(); ok {
+			return data, nil
+		}
+		missing = false
+	} else if err != nil {
+		warn(-1, "Warning: [net] Failed to fetch: %s — %v\n", mainURL, err)
+	} else {
+		warn(code, "Warning: [%d] Failed to fetch: %s\n", code, mainURL)
 	}
 
 	// Try fallbacks
 	for i, fb := range fallbacks {
-		body, code, err := tryFetch(fb)
+		body, header, code, err := tryFetch(fb)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: [net] Fallback[%d] failed: %s — %v\n", i, fb, err)
+			warn(-1, "Warning: [net] Fallback[%d] failed: %s — %v\n", i, fb, err)
 			continue
 		}
-		if code == http.StatusOK {
-			_ = os.WriteFile(cacheFilePath, body, 0644)
-			return body, nil
+		switch code {
+		case http.StatusOK:
+			if accept(fb, body, header) {
+				return body, nil
+			}
+			missing = false
+			continue
+		case http.StatusNotModified:
+			if data, ok := notModified(); ok {
+				return data, nil
+			}
 		}
-		fmt.Fprintf(os.Stderr, "Warning: [%d] Fallback[%d] failed: %s\n", code, i, fb)
+		warn(code, "Warning: [%d] Fallback[%d] failed: %s\n", code, i, fb)
 	}
 
+	if missing {
+		if repo.optional {
+			// An empty copy remembers that it is missing, so that it isn't asked for again until it is due
+			_ = os.WriteFile(cacheFilePath, nil, 0644)
+		}
+		return nil, errFileNotFound.New("%s is not published", mainURL)
+	}
 	return nil, errCacheAccess.New("fetch failed for %s", mainURL)
 }
 
-func decodeRepoIndex(config *config) ([]binaryEntry, error) {
+// decodeRepoIndex returns the entries of every repository, from the index cache while none of them has to be refreshed.
+// Otherwise the repositories are decoded concurrently, and their entries merged in the order they are configured in
+func decodeRepoIndex(config *config) (*repoIndex, error) {
+	if uRepoIndex, err := loadIndexCache(config); err == nil {
+		return uRepoIndex, nil
+	} else if verbosityLevel >= extraVerbose && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Decoding the repository indexes again: %v\n", err)
+	}
+
 	var binaryEntries []binaryEntry
 	var parsedRepos = make(map[string]bool)
+	var configIndex = make(map[*repository]int)
+	config.indexExpiry = &cacheExpiry{}
 
-	for _, repo := range config.Repositories {
-		if parsedRepos[repo.URL] {
-			continue
+	var positions []int
+	for i, repo := range config.Repositories {
+		if !parsedRepos[repo.URL] && repo.enabled() {
+			positions = append(positions, i)
 		}
+		parsedRepos[repo.URL] = true
+	}
 
-		var bodyBytes []byte
-		var err error
+	type decoded struct {
+		entries  []binaryEntry
+		degraded bool
+		err      error
+	}
+	results := make([]decoded, len(positions))
+	var wg sync.WaitGroup
+	for i, pos := range positions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].entries, results[i].degraded, results[i].err = decodeRepositoryOrCached(config, config.Repositories[pos])
+		}()
+	}
+	wg.Wait()
 
-		if strings.HasPrefix(repo.URL, "file://") {
-			bodyBytes, err = os.ReadFile(strings.TrimPrefix(repo.URL, "file://"))
-			if err != nil {
-				return nil, errFileAccess.Wrap(err)
-			}
-		} else {
-			urls := append([]string{repo.URL}, repo.FallbackURLs...)
-			bodyBytes, err = accessCachedOrFetch(urls, "", config, repo.SyncInterval)
-			if err != nil {
-				return nil, err
-			}
+	complete := true
+	for i, result := range results {
+		if result.err != nil {
+			return nil, result.err
 		}
+		if len(result.entries) > 0 {
+			configIndex[result.entries[0].Repository.repo] = positions[i]
+		}
+		binaryEntries = append(binaryEntries, result.entries...)
+		complete = complete && !result.degraded
+	}
 
-		bodyReader := io.NopCloser(bytes.NewReader(bodyBytes))
+	// Indexes that are only read from disk don't expire, their changes are noticed by the cache key
+	expires := config.indexExpiry.at
+	if expires.IsZero() {
+		expires = time.Unix(0, math.MaxInt64)
+	}
+	// Repositories that failed are tried again next time
+	if complete && time.Now().Before(expires) {
+		if err := saveIndexCache(config, binaryEntries, configIndex, expires); err != nil && verbosityLevel >= extraVerbose {
+			fmt.Fprintf(os.Stderr, "Warning: could not save the index cache: %v\n", err)
+		}
+	}
+	config.indexExpiry = nil
 
-		switch {
-		case strings.HasSuffix(repo.URL, ".gz"):
-			repo.URL = strings.TrimSuffix(repo.URL, ".gz")
-			gzipReader, err := gzip.NewReader(bodyReader)
-			if err != nil {
-				return nil, errFileTypeInvalid.Wrap(err)
-			}
-			defer gzipReader.Close()
+	return newRepoIndex(binaryEntries, config.Preferences), nil
+}
 
-			bodyBytes, err = io.ReadAll(gzipReader)
-			if err != nil {
-				return nil, errFileAccess.Wrap(err)
+// decodeRepositoryOrCached decodes repo, and if that fails, decodes its cached files instead, or skips it, reporting
+// that it degraded. Only repositories that are required make the failure an error
+func decodeRepositoryOrCached(config *config, repo repository) ([]binaryEntry, bool, error) {
+	entries, err := decodeRepository(config, repo)
+	if err == nil || repo.Required {
+		return entries, false, err
+	}
+
+	repo.cachedOnly = true
+	if cached, cacheErr := decodeRepository(config, repo); cacheErr == nil {
+		if verbosityLevel >= silentVerbosityWithErrors {
+			fmt.Fprintf(os.Stderr, "Warning: %v\nUsing the last cached index of %s\n", err, repo.URL)
+		}
+		return cached, true, nil
+	}
+	if verbosityLevel >= silentVerbosityWithErrors {
+		fmt.Fprintf(os.Stderr, "Warning: %v\nSkipping %s, it has no cached index\n", err, repo.URL)
+	}
+	return nil, true, nil
+}
+
+// fetchRepository returns the signature-verified index file of repo, as it was served
+func fetchRepository(config *config, repo repository, syncInterval time.Duration) ([]byte, error) {
+	if strings.HasPrefix(repo.URL, "file://") {
+		bodyBytes, err := os.ReadFile(strings.TrimPrefix(repo.URL, "file://"))
+		if err != nil {
+			return nil, errFileAccess.Wrap(err)
+		}
+		if err := verifyIndexSignature(config, repo, repo.URL, bodyBytes); err != nil {
+			return nil, err
+		}
+		return bodyBytes, nil
+	}
+
+	urls := append([]string{repo.URL}, repo.FallbackURLs...)
+	return accessCachedOrFetch(urls, "", config, &repo, syncInterval, func(u string, body []byte) error {
+		return verifyIndexSignature(config, repo, u, body)
+	})
+}
+
+// decompressIndex decompresses bodyBytes according to the extension of url, and returns url without it
+func decompressIndex(url string, bodyBytes []byte) ([]byte, string, error) {
+	bodyReader := io.NopCloser(bytes.NewReader(bodyBytes))
+
+	switch {
+	case strings.HasSuffix(url, ".gz"):
+		url = strings.TrimSuffix(url, ".gz")
+		gzipReader, err := gzip.NewReader(bodyReader)
+		if err != nil {
+			return nil, url, errFileTypeInvalid.Wrap(err)
+		}
+		defer gzipReader.Close()
+
+		bodyBytes, err = io.ReadAll(gzipReader)
+		if err != nil {
+			return nil, url, errFileAccess.Wrap(err)
+		}
+	case strings.HasSuffix(url, ".zst"):
+		url = strings.TrimSuffix(url, ".zst")
+		zstdReader, err := zstd.NewReader(bodyReader)
+		if err != nil {
+			return nil, url, errFileTypeInvalid.Wrap(err)
+		}
+		defer zstdReader.Close()
+
+		bodyBytes, err = io.ReadAll(zstdReader.IOReadCloser())
+		if err != nil {
+			return nil, url, errFileAccess.Wrap(err)
+		}
+	}
+
+	return bodyBytes, url, nil
+}
+
+// loadRepository fetches and decompresses the index of repo, updating it with deltas if the repository publishes
+// a timestamp document, and checking its freshness against that document unless the repo opts out.
+// Without a freshnessPolicy, the indexes that publish a timestamp document are checked with the warn policy
+func loadRepository(config *config, repo repository) ([]byte, string, error) {
+	checkFreshness := repo.FreshnessPolicy != policyOff
+
+	var err error
+	// The timestamp and the index are cached separately, if they don't match each other,
+	// one of them was refreshed before the other one, so both are fetched again, once
+	for _, syncInterval := range []time.Duration{repo.SyncInterval, 0} {
+		var ts *indexTimestamp
+		if ts, err = fetchIndexTimestamp(config, repo, syncInterval); err != nil {
+			if checkFreshness {
+				return nil, "", err
 			}
-		case strings.HasSuffix(repo.URL, ".zst"):
-			repo.URL = strings.TrimSuffix(repo.URL, ".zst")
-			zstdReader, err := zstd.NewReader(bodyReader)
-			if err != nil {
-				return nil, errFileTypeInvalid.Wrap(err)
+			// Without freshness checks, the timestamp only serves to apply deltas, the whole index will do
+			ts = nil
+		}
+		if ts != nil && !repo.forceSync && !repo.cachedOnly && !strings.HasPrefix(repo.URL, "file://") {
+			// The canonical copy was checked against the delta that produced it, or came from an index checked against ts
+			if bodyBytes, url, ok := updateIndexWithDeltas(config, repo, ts); ok {
+				if !checkFreshness {
+					return bodyBytes, url, nil
+				}
+				return bodyBytes, url, checkIndexExpiry(config, repo, ts)
 			}
-			defer zstdReader.Close()
+		}
 
-			bodyBytes, err = io.ReadAll(zstdReader.IOReadCloser())
-			if err != nil {
-				return nil, errFileAccess.Wrap(err)
-			}
+		var bodyBytes []byte
+		if bodyBytes, err = fetchRepository(config, repo, syncInterval); err != nil {
+			return nil, "", err
+		}
+		var url string
+		if bodyBytes, url, err = decompressIndex(repo.URL, bodyBytes); err != nil {
+			return nil, "", err
 		}
 
-		var repoIndex map[string][]binaryEntry
-		switch {
-		//case strings.HasSuffix(repo.URL, ".msgp"):
-		//	if err := msgpack.Unmarshal(bodyBytes, &repoIndex); err != nil {
-		//		return nil, errFileTypeInvalid.Wrap(err)
-		//	}
-		case strings.HasSuffix(repo.URL, ".cbor"):
-			if err := cbor.Unmarshal(bodyBytes, &repoIndex); err != nil {
-				return nil, errFileTypeInvalid.Wrap(err)
-			}
-		case strings.HasSuffix(repo.URL, ".json"):
-			if err := json.Unmarshal(bodyBytes, &repoIndex); err != nil {
-				return nil, errFileTypeInvalid.Wrap(err)
+		if ts == nil {
+			return bodyBytes, url, nil
+		}
+		if !checkFreshness {
+			// Deltas are only applied to an index that is known to be at the version of ts
+			if checkIndexBsum(repo, ts, bodyBytes) == nil && !strings.HasPrefix(repo.URL, "file://") {
+				recordDeltaBase(config, repo, ts.Version, url, bodyBytes)
 			}
-		case strings.HasSuffix(repo.URL, ".yaml"):
-			if err := yaml.Unmarshal(bodyBytes, &repoIndex); err != nil {
-				return nil, errFileTypeInvalid.Wrap(err)
+			return bodyBytes, url, nil
+		}
+		if err = checkIndexFreshness(config, repo, ts, bodyBytes); err == nil {
+			if !strings.HasPrefix(repo.URL, "file://") {
+				recordDeltaBase(config, repo, ts.Version, url, bodyBytes)
 			}
-		default:
-			return nil, errFileTypeInvalid.New("unsupported format for URL: %s", repo.URL)
+			return bodyBytes, url, nil
 		}
+	}
 
-		for repoName, entries := range repoIndex {
-			for _, entry := range entries {
-				entry.Repository = repo
-				entry.Repository.Name = repoName
-				binaryEntries = append(binaryEntries, entry)
-			}
+	return nil, "", err
+}
+
+func decodeRepository(config *config, repo repository) ([]binaryEntry, error) {
+	bodyBytes, url, err := loadRepository(config, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if repo.revocations, err = fetchRevocationList(config, repo); err != nil {
+		return nil, err
+	}
+
+	var repoIndex map[string][]binaryEntry
+	switch {
+	//case strings.HasSuffix(url, ".msgp"):
+	//	if err := msgpack.Unmarshal(bodyBytes, &repoIndex); err != nil {
+	//		return nil, errFileTypeInvalid.Wrap(err)
+	//	}
+	case strings.HasSuffix(url, ".cbor"):
+		if err := cbor.Unmarshal(bodyBytes, &repoIndex); err != nil {
+			return nil, errFileTypeInvalid.Wrap(err)
 		}
+	case strings.HasSuffix(url, ".json"):
+		if err := json.Unmarshal(bodyBytes, &repoIndex); err != nil {
+			return nil, errFileTypeInvalid.Wrap(err)
+		}
+	case strings.HasSuffix(url, ".yaml"):
+		if err := yaml.Unmarshal(bodyBytes, &repoIndex); err != nil {
+			return nil, errFileTypeInvalid.Wrap(err)
+		}
+	default:
+		return nil, errFileTypeInvalid.New("unsupported format for URL: %s", url)
+	}
 
-		parsedRepos[repo.URL] = true
+	// In a stable order, so that candidates that tie when resolving a binary are always picked the same way
+	repoNames := make([]string, 0, len(repoIndex))
+	for repoName := range repoIndex {
+		repoNames = append(repoNames, repoName)
+	}
+	slices.Sort(repoNames)
+
+	var binaryEntries []binaryEntry
+	for _, repoName := range repoNames {
+		for _, entry := range repoIndex[repoName] {
+			entry.Repository = repoRef{Name: repoName, repo: &repo}
+			binaryEntries = append(binaryEntries, entry)
+		}
 	}
 
 	return binaryEntries, nil
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/goccy/go-json"
+	"github.com/urfave/cli/v3"
+	"github.com/zeebo/errs"
+)
+
+var (
+	errVerifyFailed = errs.Class("verification failed")
+)
+
+const (
+	verifyIntact          = "intact"
+	verifyModified        = "modified"
+	verifyMissingMetadata = "missing_metadata"
+)
+
+type verifyResult struct {
+	Name         string `json:"name"`
+	PkgID        string `json:"pkg_id,omitempty"`
+	Path         string `json:"path"`
+	Status       string `json:"status"`
+	RecordedBsum string `json:"recorded_bsum,omitempty"`
+	ActualBsum   string `json:"actual_bsum,omitempty"`
+}
+
+func verifyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "verify",
+		Usage: "Check that installed binaries were not modified since dbin installed them",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print output as JSON",
+			},
+		},
+		Action: func(_ context.Context, c *cli.Command) error {
+			config, err := loadConfig()
+			if err != nil {
+				return errVerifyFailed.Wrap(err)
+			}
+			return verifyInstalled(config, arrStringToArrBinaryEntry(c.Args().Slice()), c.Bool("json"))
+		},
+	}
+}
+
+func verifyInstalled(config *config, programsToVerify []binaryEntry, asJSON bool) error {
+	programs, err := validateProgramsFrom(config, programsToVerify, nil)
+	if err != nil {
+		return errVerifyFailed.Wrap(err)
+	}
+
+	results := make([]verifyResult, 0, len(programs))
+	var modified int
+	for _, program := range programs {
+		binaryPath := filepath.Join(config.InstallDir, filepath.Base(program.Name))
+		result := verifyResult{
+			Name:         program.Name,
+			PkgID:        program.PkgID,
+			Path:         binaryPath,
+			RecordedBsum: program.localBsum,
+		}
+
+		result.ActualBsum, err = calculateChecksum(binaryPath)
+		if err != nil {
+			return errVerifyFailed.Wrap(err)
+		}
+
+		switch {
+		case result.RecordedBsum == "":
+			result.Status = verifyMissingMetadata
+		case result.RecordedBsum == result.ActualBsum:
+			result.Status = verifyIntact
+		default:
+			result.Status = verifyModified
+			modified++
+		}
+		results = append(results, result)
+	}
+
+	if asJSON {
+		jsonData, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return errVerifyFailed.Wrap(err)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		for _, result := range results {
+			switch result.Status {
+			case verifyModified:
+				if verbosityLevel >= silentVerbosityWithErrors {
+					fmt.Printf("%s%s#%s%s: modified locally (installed as %s, now %s)\n", yellowColor, result.Name, result.PkgID, resetColor, result.RecordedBsum, result.ActualBsum)
+				}
+			case verifyMissingMetadata:
+				if verbosityLevel >= normalVerbosity {
+					fmt.Printf("%s#%s: no digest was recorded when it was installed\n", result.Name, result.PkgID)
+				}
+			default:
+				if verbosityLevel >= extraVerbose {
+					fmt.Printf("%s#%s: intact\n", result.Name, result.PkgID)
+				}
+			}
+		}
+	}
+
+	if modified > 0 {
+		return errVerifyFailed.New("%d of %d installed binaries were modified", modified, len(results))
+	}
+	return nil
+}